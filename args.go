@@ -19,6 +19,10 @@ var Stats = flag.Bool("stats", false, "report stats on exit")
 // Concurrency determines how many parse workers run simultaneously.
 var Concurrency = flag.IntP("concurrency", "j", 8, "number of concurrent workers (jobs). default: 8")
 
+// Defines are the preprocessor macros given on the command line, as NAME or NAME=value,
+// see Preprocessor.DefineArgs.
+var Defines = flag.StringArrayP("define", "D", nil, "define a preprocessor macro as NAME or NAME=value")
+
 // CommonCommandLine parses common command line options.
 func CommonCommandLine() {
 	flag.Parse()
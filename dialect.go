@@ -0,0 +1,222 @@
+package parsing
+
+import (
+	"io"
+	"sort"
+	"sync/atomic"
+)
+
+// CommentStyle describes one comment syntax of a Dialect. Comments begin with
+// Open and run until Close, or until end-of-line if Close is empty. Nested
+// block comments must see a Close for every Open they contain, so that
+// "/* a /* b */ c */" is a single comment.
+//
+// An Open that ends with a letter or digit, such as "REM", only begins a
+// comment if it isn't immediately followed by more of an identifier.
+type CommentStyle struct {
+	Open   string
+	Close  string
+	Nested bool
+}
+
+// Dialect describes the lexical rules of a language: how characters are
+// classified, its keywords, operators, comment syntaxes, string delimiters and which
+// characters may appear in identifiers. Each Lexer follows the rules of
+// one Dialect, so that lexers for different languages can run side by side.
+//
+// Dialects are not safe to modify while a lexer is using them.
+type Dialect struct {
+	// Name describes the dialect for humans.
+	Name string
+
+	charMap         *[256]Token               // initial classification of each character
+	identifier      [256]bool                 // extra characters that may continue an identifier
+	keywords        map[string]Token          // keyword terminals
+	flaggedKeywords map[string]flaggedKeyword // keywords with KeywordFlags
+	comments        []CommentStyle            // comment syntaxes, longest Open first
+	commentStarts   [256]bool                 // first characters of comment syntaxes
+	unicode         bool                      // whether lexers start in unicode mode
+	indentation     bool                      // whether lexers start in indentation mode
+	lineDirectives  []string                  // prefixes of line directives, see AddLineDirective
+	numbers         NumberSyntax              // numeric literal forms accepted
+	numberSuffixes  []string                  // type suffixes for numbers, longest first
+	strings         StringSyntax              // string literal forms accepted
+	operators       []operator                // multi-character operators, longest first
+	operatorStarts  [256]bool                 // first characters of operators
+	intercepts      InterceptTable            // intercepts, see AddIntercept
+	rules           *interceptSet             // cursor intercepts, see AddInterceptRule
+	patterns        []pattern                 // token patterns, see AddPattern
+	automaton       atomic.Value              // *dfa compiled from patterns etc
+}
+
+// DefaultDialect classifies characters using the package-level TokenMap and
+// recognizes C++-style single- (//) and multi-line (/*...*/) comments.
+var DefaultDialect = newDefaultDialect()
+
+// cppComments are the comment syntaxes of the DefaultDialect.
+var cppComments = []CommentStyle{{Open: "//"}, {Open: "/*", Close: "*/"}}
+
+func newDefaultDialect() *Dialect {
+	d := &Dialect{Name: "default", charMap: &TokenMap}
+	d.SetComments(cppComments...)
+	return d
+}
+
+// NewDialect returns a new Dialect with the same rules as the DefaultDialect
+// but with its own copy of the character map, so that it can be customized
+// without affecting other lexers.
+func NewDialect(name string) *Dialect {
+	charMap := TokenMap
+	d := &Dialect{Name: name, charMap: &charMap}
+	d.SetComments(cppComments...)
+	return d
+}
+
+// NewLexer constructs a Lexer that will follow the rules of this dialect.
+// A byte-order mark at the start of code is removed, and UTF-16 code is
+// transcoded to UTF-8.
+func (d *Dialect) NewLexer(name string, code []byte) *Lexer {
+	code, offsets := decodeSource(code)
+	l := &Lexer{
+		name:       name,
+		code:       code,
+		dialect:    d,
+		Start:      0,
+		End:        0,
+		Token:      InvalidToken,
+		keywords:   nil,
+		intercepts: nil,
+		unicode:    d.unicode,
+		offsets:    offsets,
+		interning:  true,
+	}
+	l.SetIndentation(d.indentation)
+	return l
+}
+
+// NewReaderLexer constructs a streaming Lexer, see NewReaderLexer, that will
+// follow the rules of this dialect.
+func (d *Dialect) NewReaderLexer(name string, reader io.Reader) *Lexer {
+	l := d.NewLexer(name, make([]byte, 0, readerChunkSize))
+	l.reader = decodeReader(reader, &l.offsets)
+	l.retain = -1
+	l.interning = false
+	return l
+}
+
+// Class returns the base Token type of a character.
+func (d *Dialect) Class(char byte) Token {
+	return d.charMap[char]
+}
+
+// SetClass changes the base Token type of a character, e.g. making '$' an
+// AlphaToken allows identifiers to start with a '$'.
+func (d *Dialect) SetClass(char byte, token Token) {
+	d.charMap[char] = token
+	d.invalidate()
+}
+
+// SetQuotes replaces the set of characters that delimit strings. Characters
+// that no longer delimit strings become SymbolTokens.
+func (d *Dialect) SetQuotes(quotes string) {
+	for char, token := range d.charMap {
+		if token == StringToken {
+			d.charMap[char] = SymbolToken
+		}
+	}
+	for _, char := range []byte(quotes) {
+		d.charMap[char] = StringToken
+	}
+	d.invalidate()
+}
+
+// AddIdentifierChars allows identifiers to contain the given characters after
+// their first character, in addition to letters, digits and underscores.
+func (d *Dialect) AddIdentifierChars(chars string) {
+	for _, char := range []byte(chars) {
+		d.identifier[char] = true
+	}
+	d.invalidate()
+}
+
+// IsIdentifierContinuation returns true for characters that are allowed to
+// continue an identifier (2nd character+).
+func (d *Dialect) IsIdentifierContinuation(char byte) bool {
+	switch d.charMap[char] {
+	case AlphaToken, DigitToken, Underscore:
+		return true
+	}
+	return d.identifier[char]
+}
+
+// isNumeric returns true if the character is a digit or period.
+func (d *Dialect) isNumeric(char byte) bool {
+	return d.charMap[char] == DigitToken || d.charMap[char] == Period
+}
+
+// AddKeyword registers a keyword Terminal with the dialect, see Lexer.AddKeyword.
+func (d *Dialect) AddKeyword(keyword string, token Token) {
+	checkKeywordToken(token)
+	if d.keywords == nil {
+		d.keywords = make(map[string]Token)
+	}
+	d.keywords[keyword] = token
+	d.invalidate()
+}
+
+// Keyword returns the Terminal registered for a reserved keyword, if any.
+// Contextual keywords are not included.
+func (d *Dialect) Keyword(word string) (Token, bool) {
+	if token, ok := d.keywords[word]; ok {
+		return token, true
+	}
+	if keyword, ok := lookupFlaggedKeyword(d.flaggedKeywords, word); ok && keyword.flags&Contextual == 0 {
+		return keyword.token, true
+	}
+	return InvalidToken, false
+}
+
+// SetComments replaces the comment syntaxes of the dialect. Pass no styles
+// for a language without comments.
+func (d *Dialect) SetComments(styles ...CommentStyle) {
+	comments := make([]CommentStyle, 0, len(styles))
+	d.commentStarts = [256]bool{}
+	for _, style := range styles {
+		if style.Open == "" {
+			panic("comment styles must have an opening sequence")
+		}
+		comments = append(comments, style)
+		d.commentStarts[style.Open[0]] = true
+	}
+	// Longest match first, so that e.g. "///" can be distinguished from "//".
+	sort.SliceStable(comments, func(i, j int) bool {
+		return len(comments[i].Open) > len(comments[j].Open)
+	})
+	d.comments = comments
+}
+
+// AddLineComment adds a comment syntax that runs from prefix to end-of-line,
+// such as "#", "--" or ";".
+func (d *Dialect) AddLineComment(prefix string) {
+	d.SetComments(append(d.comments, CommentStyle{Open: prefix})...)
+}
+
+// AddBlockComment adds a comment syntax that runs from open to close, which
+// may span multiple lines, such as "(*" and "*)".
+func (d *Dialect) AddBlockComment(open, close string, nested bool) {
+	if close == "" {
+		panic("block comments must have a closing sequence")
+	}
+	d.SetComments(append(d.comments, CommentStyle{Open: open, Close: close, Nested: nested})...)
+}
+
+// Comments returns the comment syntaxes of the dialect.
+func (d *Dialect) Comments() []CommentStyle {
+	return d.comments
+}
+
+// SetUnicode determines whether lexers for this dialect start in unicode mode,
+// see Lexer.SetUnicode.
+func (d *Dialect) SetUnicode(enabled bool) {
+	d.unicode = enabled
+}
@@ -0,0 +1,245 @@
+package parsing
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	dialectLet   = NewTerminal("dialect-let")
+	dialectLocal = NewTerminal("dialect-local")
+)
+
+// lexTokens returns the significant tokens and their values for code.
+func lexTokens(l *Lexer) (tokens []Token, values []string) {
+	for l.Advance() {
+		if IsSignificant(l.Token) {
+			tokens = append(tokens, l.Token)
+			values = append(values, l.String())
+		}
+	}
+	return
+}
+
+func TestDefaultDialect(t *testing.T) {
+	require.NotNil(t, DefaultDialect)
+	assert.True(t, DefaultDialect.charMap == &TokenMap)
+	assert.Equal(t, cppComments, DefaultDialect.Comments())
+	assert.Equal(t, DefaultDialect, NewLexer("x", nil).Dialect())
+	assert.Equal(t, DefaultDialect, (&Lexer{}).Dialect())
+}
+
+func TestNewDialect(t *testing.T) {
+	d := NewDialect("test")
+	if assert.NotNil(t, d) {
+		assert.Equal(t, "test", d.Name)
+		assert.False(t, d.charMap == &TokenMap)
+		assert.Equal(t, TokenMap, *d.charMap)
+		assert.Equal(t, cppComments, d.Comments())
+		assert.Nil(t, d.keywords)
+	}
+}
+
+func TestDialect_NewLexer(t *testing.T) {
+	d := NewDialect("test")
+	d.SetUnicode(true)
+	l := d.NewLexer("file", []byte("code"))
+	if assert.NotNil(t, l) {
+		assert.Equal(t, d, l.Dialect())
+		assert.Equal(t, "file", l.Filename())
+		assert.True(t, l.unicode)
+	}
+}
+
+func TestDialect_SetClass(t *testing.T) {
+	d := NewDialect("dollars")
+	d.SetClass('$', AlphaToken)
+	assert.Equal(t, AlphaToken, d.Class('$'))
+	assert.Equal(t, Dollar, TokenMap['$'])
+
+	tokens, values := lexTokens(d.NewLexer("dollars", []byte("$x")))
+	assert.Equal(t, []Token{IdentifierToken}, tokens)
+	assert.Equal(t, []string{"$x"}, values)
+
+	tokens, _ = lexTokens(NewLexer("default", []byte("$x")))
+	assert.Equal(t, []Token{Dollar, IdentifierToken}, tokens)
+}
+
+func TestDialect_SetQuotes(t *testing.T) {
+	d := NewDialect("backticks")
+	d.SetQuotes("`")
+	tokens, values := lexTokens(d.NewLexer("quotes", []byte("`it's \"here\"` 'x")))
+	assert.Equal(t, []Token{StringToken, SymbolToken, IdentifierToken}, tokens)
+	assert.Equal(t, []string{"`it's \"here\"`", "'", "x"}, values)
+}
+
+func TestDialect_AddIdentifierChars(t *testing.T) {
+	d := NewDialect("lisp")
+	d.AddIdentifierChars("-?")
+	if assert.True(t, d.IsIdentifierContinuation('-')) {
+		assert.False(t, d.IsIdentifierContinuation('+'))
+		assert.True(t, d.IsIdentifierContinuation('_'))
+		assert.False(t, IsIdentifierContinuation('-'))
+	}
+	tokens, values := lexTokens(d.NewLexer("lisp", []byte("empty-list? -x")))
+	assert.Equal(t, []Token{IdentifierToken, Minus, IdentifierToken}, tokens)
+	assert.Equal(t, []string{"empty-list?", "-", "x"}, values)
+}
+
+func TestDialect_AddKeyword(t *testing.T) {
+	d := NewDialect("keywords")
+	assert.Panics(t, func() { d.AddKeyword("let", IdentifierToken) })
+	d.AddKeyword("let", dialectLet)
+	d.AddKeyword("local", dialectLet)
+	token, ok := d.Keyword("let")
+	if assert.True(t, ok) {
+		assert.Equal(t, dialectLet, token)
+	}
+
+	l := d.NewLexer("keywords", []byte("let local lettuce"))
+	l.AddKeyword("local", dialectLocal)
+	tokens, _ := lexTokens(l)
+	assert.Equal(t, []Token{dialectLet, dialectLocal, IdentifierToken}, tokens)
+
+	tokens, _ = lexTokens(NewLexer("default", []byte("let")))
+	assert.Equal(t, []Token{IdentifierToken}, tokens)
+}
+
+func TestDialect_SetComments(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		l := NewLexer("comments", []byte("a // b\nc /* d\ne */ f"))
+		var tokens []Token
+		var values []string
+		for l.Advance() {
+			tokens = append(tokens, l.Token)
+			values = append(values, l.String())
+		}
+		assert.Equal(t, []Token{
+			IdentifierToken, WhitespaceToken, CommentToken, NewlineToken,
+			IdentifierToken, WhitespaceToken, CommentToken, WhitespaceToken, IdentifierToken,
+		}, tokens)
+		assert.Equal(t, "// b", values[2])
+		assert.Equal(t, "/* d\ne */", values[6])
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		d := NewDialect("shell")
+		d.SetComments(CommentStyle{Open: "#"})
+		assert.Equal(t, []CommentStyle{{Open: "#"}}, d.Comments())
+		tokens, values := lexTokens(d.NewLexer("shell", []byte("a # b // c\n//d")))
+		assert.Equal(t, []Token{IdentifierToken, Slash, Slash, IdentifierToken}, tokens)
+		assert.Equal(t, []string{"a", "/", "/", "d"}, values)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		d := NewDialect("no comments")
+		d.SetComments()
+		tokens, _ := lexTokens(d.NewLexer("none", []byte("/*x*/")))
+		assert.Equal(t, []Token{Slash, Asterisk, IdentifierToken, Asterisk, Slash}, tokens)
+	})
+
+	t.Run("longest first", func(t *testing.T) {
+		d := NewDialect("docs")
+		d.SetComments(CommentStyle{Open: "/"}, CommentStyle{Open: "/*", Close: "*/"})
+		assert.Equal(t, "/*", d.Comments()[0].Open)
+	})
+
+	assert.Panics(t, func() { NewDialect("bad").SetComments(CommentStyle{}) })
+}
+
+func TestDialect_concurrent(t *testing.T) {
+	hash := NewDialect("hash")
+	hash.SetComments(CommentStyle{Open: "#"})
+	hash.AddIdentifierChars("-")
+
+	var wg sync.WaitGroup
+	results := make([][]Token, 2)
+	for idx, d := range []*Dialect{DefaultDialect, hash} {
+		wg.Add(1)
+		go func(idx int, d *Dialect) {
+			defer wg.Done()
+			results[idx], _ = lexTokens(d.NewLexer("concurrent", []byte("a-b # c")))
+		}(idx, d)
+	}
+	wg.Wait()
+	assert.Equal(t, []Token{IdentifierToken, Minus, IdentifierToken, SymbolToken, IdentifierToken}, results[0])
+	assert.Equal(t, []Token{IdentifierToken}, results[1])
+}
+
+func TestDialect_AddLineComment(t *testing.T) {
+	d := NewDialect("config")
+	d.SetComments()
+	for _, prefix := range []string{"#", "--", ";", "REM"} {
+		d.AddLineComment(prefix)
+	}
+	tests := []struct {
+		name, code string
+		tokens     []Token
+		values     []string
+	}{
+		{"hash", "a # b\nc", []Token{IdentifierToken, IdentifierToken}, []string{"a", "c"}},
+		{"double-dash", "a - -1 -- b", []Token{IdentifierToken, Minus, IntegerToken}, []string{"a", "-", "-1"}},
+		{"semicolon", "a; b", []Token{IdentifierToken}, []string{"a"}},
+		{"word", "REM hi\nREMARK", []Token{IdentifierToken}, []string{"REMARK"}},
+		{"word at eof", "x REM", []Token{IdentifierToken}, []string{"x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, values := lexTokens(d.NewLexer(tt.name, []byte(tt.code)))
+			assert.Equal(t, tt.tokens, tokens)
+			assert.Equal(t, tt.values, values)
+		})
+	}
+
+	t.Run("excludes newline", func(t *testing.T) {
+		l := d.NewLexer("newline", []byte("# x\r\n"))
+		if assert.True(t, l.Advance()) && assert.Equal(t, CommentToken, l.Token) {
+			assert.Equal(t, "# x", l.String())
+		}
+		if assert.True(t, l.Advance()) {
+			assert.Equal(t, NewlineToken, l.Token)
+		}
+	})
+}
+
+func TestDialect_AddBlockComment(t *testing.T) {
+	assert.Panics(t, func() { NewDialect("bad").AddBlockComment("(*", "", false) })
+
+	d := NewDialect("pascal")
+	d.SetComments()
+	d.AddBlockComment("(*", "*)", true)
+	d.AddBlockComment("{", "}", false)
+	assert.Equal(t, []CommentStyle{{Open: "(*", Close: "*)", Nested: true}, {Open: "{", Close: "}"}}, d.Comments())
+
+	tests := []struct {
+		name, code string
+		values     []string
+	}{
+		{"paren", "a (* b *) c", []string{"a", "c"}},
+		{"not a comment", "(a*)", []string{"(", "a", "*", ")"}},
+		{"nested", "a (* (* b *) c *) d", []string{"a", "d"}},
+		{"multi-line", "a (*\n(*\n*)\n*)\nd", []string{"a", "d"}},
+		{"not nested", "a { { b } c } d", []string{"a", "c", "}", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, values := lexTokens(d.NewLexer(tt.name, []byte(tt.code)))
+			assert.Equal(t, tt.values, values)
+		})
+	}
+
+	t.Run("unterminated nesting", func(t *testing.T) {
+		l := d.NewLexer("unterminated.test", []byte("(* (* *)"))
+		assert.PanicsWithError(t, "unterminated.test:1:1-1:9: error: unterminated multiline comment", func() { lexTokens(l) })
+	})
+
+	t.Run("nested C", func(t *testing.T) {
+		c := NewDialect("nested C")
+		c.SetComments(CommentStyle{Open: "//"}, CommentStyle{Open: "/*", Close: "*/", Nested: true})
+		_, values := lexTokens(c.NewLexer("nested", []byte("a /* /* */ b */ c // d")))
+		assert.Equal(t, []string{"a", "c"}, values)
+	})
+}
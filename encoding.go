@@ -0,0 +1,209 @@
+package parsing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Lexers recognize a byte-order mark at the start of a source. A UTF-8 BOM
+// is skipped, and UTF-16 sources, big- or little-endian, are transcoded to
+// UTF-8 as they are read. Offsets (Start, End, Symbol offsets etc) are then
+// positions in the UTF-8 text, without the BOM, while the offsets of a
+// LexError are positions in the original file, see Lexer.SourceOffset.
+
+// Byte-order marks.
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+)
+
+// offsetRun describes a run of characters that are width bytes long in the
+// decoded text and originalWidth bytes long in the original source.
+type offsetRun struct {
+	offset, original     int
+	width, originalWidth int
+}
+
+// offsetMap translates offsets in decoded text to the original source.
+type offsetMap []offsetRun
+
+// add records that the next character of the decoded text, at offset, came
+// from originalWidth bytes at original.
+func (m *offsetMap) add(offset, original, width, originalWidth int) {
+	if runs := *m; len(runs) > 0 {
+		if last := runs[len(runs)-1]; last.width == width && last.originalWidth == originalWidth {
+			return
+		}
+	}
+	*m = append(*m, offsetRun{offset, original, width, originalWidth})
+}
+
+// original returns the offset in the original source of the character at
+// offset in the decoded text.
+func (m *offsetMap) original(offset int) int {
+	if m == nil || len(*m) == 0 {
+		return offset
+	}
+	runs := *m
+	idx := sort.Search(len(runs), func(i int) bool { return runs[i].offset > offset }) - 1
+	if idx < 0 {
+		return offset
+	}
+	run := runs[idx]
+	return run.original + (offset-run.offset)/run.width*run.originalWidth
+}
+
+// SourceOffset returns the position in the original file of an offset in
+// the text being lexed. They differ for sources that began with a byte-order
+// mark, particularly those transcoded from UTF-16.
+func (l *Lexer) SourceOffset(offset int) int {
+	return l.offsets.original(offset)
+}
+
+// decodeSource removes any byte-order mark from code, transcoding it from
+// UTF-16 if necessary. offsets is nil if the text is unchanged.
+func decodeSource(code []byte) (text []byte, offsets *offsetMap) {
+	switch {
+	case bytes.HasPrefix(code, utf8BOM):
+		return code[len(utf8BOM):], &offsetMap{{0, len(utf8BOM), 1, 1}}
+	case bytes.HasPrefix(code, utf16BEBOM), bytes.HasPrefix(code, utf16LEBOM):
+		reader := newUTF16Reader(bytes.NewReader(code[2:]), code[0] == 0xFE)
+		// Reading from memory can't fail, and invalid input is replaced.
+		text, _ = ioutil.ReadAll(reader)
+		return text, reader.offsets
+	}
+	return code, nil
+}
+
+// decodeReader returns a reader that removes any byte-order mark from the
+// stream, transcoding it from UTF-16 if necessary, and records the offsets
+// of transcoded text in offsets as it is read.
+func decodeReader(reader io.Reader, offsets **offsetMap) io.Reader {
+	return &bomReader{source: reader, offsets: offsets}
+}
+
+// bomReader examines the start of a stream for a byte-order mark on the first
+// call to Read.
+type bomReader struct {
+	source  io.Reader
+	offsets **offsetMap
+	decoded io.Reader
+}
+
+func (r *bomReader) Read(p []byte) (n int, err error) {
+	if r.decoded != nil {
+		return r.decoded.Read(p)
+	}
+	r.decoded = r.source
+	if len(p) < len(utf8BOM) {
+		// Too small to recognize a BOM in.
+		return r.source.Read(p)
+	}
+	for n < len(utf8BOM) && err == nil {
+		var read int
+		read, err = r.source.Read(p[n:])
+		n += read
+	}
+	switch {
+	case bytes.HasPrefix(p[:n], utf8BOM):
+		*r.offsets = &offsetMap{{0, len(utf8BOM), 1, 1}}
+		return copy(p, p[len(utf8BOM):n]), err
+	case bytes.HasPrefix(p[:n], utf16BEBOM), bytes.HasPrefix(p[:n], utf16LEBOM):
+		tail := r.source
+		if err != nil {
+			tail = failedReader{err}
+		}
+		decoder := newUTF16Reader(io.MultiReader(bytes.NewReader(append([]byte(nil), p[2:n]...)), tail), p[0] == 0xFE)
+		*r.offsets = decoder.offsets
+		r.decoded = decoder
+		return decoder.Read(p)
+	}
+	return n, err
+}
+
+// failedReader returns the error that ended a stream while looking for a BOM.
+type failedReader struct{ err error }
+
+func (r failedReader) Read([]byte) (int, error) { return 0, r.err }
+
+// utf16Reader transcodes a UTF-16 stream, that followed a 2-byte BOM, to
+// UTF-8. Invalid sequences are replaced by utf8.RuneError.
+type utf16Reader struct {
+	source    io.Reader
+	order     binary.ByteOrder
+	offsets   *offsetMap
+	input     []byte // source bytes not yet decoded
+	output    []byte // decoded text not yet returned
+	original  int    // offset in the source of input[0]
+	decoded   int    // number of bytes of text decoded
+	sourceErr error
+}
+
+func newUTF16Reader(source io.Reader, bigEndian bool) *utf16Reader {
+	r := &utf16Reader{source: source, order: binary.LittleEndian, offsets: &offsetMap{}, original: 2}
+	if bigEndian {
+		r.order = binary.BigEndian
+	}
+	return r
+}
+
+func (r *utf16Reader) Read(p []byte) (int, error) {
+	for len(r.output) == 0 {
+		if r.sourceErr != nil {
+			if len(r.input) == 0 {
+				return 0, r.sourceErr
+			}
+		} else {
+			var chunk [4096]byte
+			n, err := r.source.Read(chunk[:])
+			r.input, r.sourceErr = append(r.input, chunk[:n]...), err
+		}
+		r.decode()
+	}
+	n := copy(p, r.output)
+	r.output = r.output[n:]
+	return n, nil
+}
+
+// decode transcodes as much of the input as possible. Incomplete sequences
+// are kept for the next read, unless the source has ended.
+func (r *utf16Reader) decode() {
+	atEnd := r.sourceErr != nil
+	for len(r.input) > 0 {
+		char, size := utf8.RuneError, 2
+		switch {
+		case len(r.input) < 2:
+			if !atEnd {
+				return
+			}
+			size = 1
+		default:
+			unit := rune(r.order.Uint16(r.input))
+			char = unit
+			if utf16.IsSurrogate(unit) {
+				char = utf8.RuneError
+				if len(r.input) < 4 && !atEnd {
+					return
+				}
+				if len(r.input) >= 4 {
+					if pair := utf16.DecodeRune(unit, rune(r.order.Uint16(r.input[2:]))); pair != utf8.RuneError {
+						char, size = pair, 4
+					}
+				}
+			}
+		}
+		var encoded [utf8.UTFMax]byte
+		width := utf8.EncodeRune(encoded[:], char)
+		r.offsets.add(r.decoded, r.original, width, size)
+		r.output = append(r.output, encoded[:width]...)
+		r.input = r.input[size:]
+		r.decoded += width
+		r.original += size
+	}
+}
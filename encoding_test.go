@@ -0,0 +1,122 @@
+package parsing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeUTF16 returns text encoded as UTF-16 with a byte-order mark.
+func encodeUTF16(text string, bigEndian bool) []byte {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	var encoded bytes.Buffer
+	binary.Write(&encoded, order, uint16(0xFEFF))
+	binary.Write(&encoded, order, utf16.Encode([]rune(text)))
+	return encoded.Bytes()
+}
+
+func Test_decodeSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    []byte
+		text    string
+		offsets []int // original offset of each byte of text, and the end
+	}{
+		{"plain", []byte("ab"), "ab", []int{0, 1, 2}},
+		{"utf-8 bom", []byte("\xEF\xBB\xBFab"), "ab", []int{3, 4, 5}},
+		{"utf-8 bom only", []byte("\xEF\xBB\xBF"), "", []int{3}},
+		{"utf-16le", encodeUTF16("ab", false), "ab", []int{2, 4, 6}},
+		{"utf-16be", encodeUTF16("ab", true), "ab", []int{2, 4, 6}},
+		{"utf-16 multibyte", encodeUTF16("aé€b", false), "aé€b", []int{2, 4, 4, 6, 6, 6, 8, 10}},
+		{"utf-16 surrogate pair", encodeUTF16("a😀b", true), "a😀b", []int{2, 4, 4, 4, 4, 8, 10}},
+		{"utf-16 odd length", append(encodeUTF16("a", false), 'x'), "a�", []int{2, 4, 4, 4, 5}},
+		{"utf-16 lone surrogate", []byte{0xFF, 0xFE, 0x00, 0xD8, 'a', 0}, "�a", []int{2, 2, 2, 4, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, offsets := decodeSource(tt.code)
+			assert.Equal(t, tt.text, string(text))
+			var original []int
+			for offset := 0; offset <= len(text); offset++ {
+				original = append(original, offsets.original(offset))
+			}
+			assert.Equal(t, tt.offsets, original)
+		})
+	}
+
+	code := []byte("no bom")
+	text, offsets := decodeSource(code)
+	assert.Nil(t, offsets)
+	assert.Equal(t, &code[0], &text[0])
+}
+
+func TestNewLexer_byteOrderMarks(t *testing.T) {
+	const source = "first\n  second 'third"
+	tests := []struct {
+		name  string
+		code  []byte
+		start int
+	}{
+		{"none", []byte(source), 15},
+		{"utf-8", append([]byte("\xEF\xBB\xBF"), source...), 18},
+		{"utf-16le", encodeUTF16(source, false), 32},
+		{"utf-16be", encodeUTF16(source, true), 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, l := range []*Lexer{
+				NewLexer("bom.test", tt.code),
+				NewReaderLexer("bom.test", iotest.OneByteReader(bytes.NewReader(tt.code))),
+			} {
+				var values []string
+				for len(values) < 5 && l.Advance() {
+					values = append(values, l.String())
+				}
+				assert.Equal(t, []string{"first", "\n", "  ", "second", " "}, values)
+				assert.Equal(t, 2, l.LineNo(l.End))
+				assert.Equal(t, 10, l.CharNo(l.End))
+				assert.Equal(t, tt.start, l.SourceOffset(l.End))
+
+				l.SetRecovery(true)
+				l.Advance()
+				if assert.Len(t, l.Errors(), 1) {
+					assert.Equal(t, tt.start, l.Errors()[0].Start)
+					assert.Equal(t, "bom.test:2:10-2:16: error: unterminated string/missing close-quote?", l.Errors()[0].Error())
+				}
+			}
+		})
+	}
+}
+
+func TestNewReaderLexer_byteOrderMarks(t *testing.T) {
+	code := strings.Repeat("word ", 100)
+	withChunkSize(7, func() {
+		for _, encoded := range [][]byte{[]byte(code), append([]byte("\xEF\xBB\xBF"), code...), encodeUTF16(code, false)} {
+			l := NewReaderLexer("stream.test", bytes.NewReader(encoded))
+			tokens, err := Tokenize(l, true)
+			require.NoError(t, err)
+			assert.Len(t, tokens, 200)
+		}
+	})
+}
+
+func TestLexError_transcoded(t *testing.T) {
+	// A single-character error is reported as such, although the character
+	// is two bytes in the original file.
+	l := NewLexer("bom.test", encodeUTF16("ab '", false))
+	_, err := Tokenize(l, false)
+	if assert.IsType(t, &LexError{}, err) {
+		assert.Equal(t, 8, err.(*LexError).Start)
+		assert.Equal(t, 10, err.(*LexError).End)
+		assert.EqualError(t, err, "bom.test:1:4: error: unterminated string/missing close-quote?")
+	}
+}
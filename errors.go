@@ -0,0 +1,96 @@
+package parsing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LexError describes a problem the lexer encountered in a source file.
+type LexError struct {
+	// Filename is the name of the file being lexed.
+	Filename string
+	// Start and End are the byte-offsets of the problematic text in the
+	// original file, see Lexer.SourceOffset.
+	Start, End int
+	// Pos is the position of the start of the text in the lexer's FileSet,
+	// or NoPos if it doesn't have one, see Lexer.SetFileSet.
+	Pos Pos
+	// StartLine, StartChar, EndLine and EndChar are the line and character
+	// numbers corresponding to Start and End.
+	StartLine, StartChar int
+	EndLine, EndChar     int
+	// Message describes the problem.
+	Message string
+	// IncludedFrom lists the locations of the includes through which the
+	// file was reached, innermost first, see Lexer.SetIncludes.
+	IncludedFrom []string
+}
+
+// Error formats the error as "file:line:char[-line:char]: error: message",
+// followed by an "included from" line for each include.
+func (e *LexError) Error() string {
+	location := fmt.Sprintf("%s:%d:%d", e.Filename, e.StartLine, e.StartChar)
+	// Transcoded characters may be more than one byte in the original file.
+	if e.End > e.Start+1 && (e.EndLine != e.StartLine || e.EndChar > e.StartChar+1) {
+		location += fmt.Sprintf("-%d:%d", e.EndLine, e.EndChar)
+	}
+	message := location + ": error: " + e.Message
+	for _, include := range e.IncludedFrom {
+		message += "\n\tincluded from " + include
+	}
+	return message
+}
+
+// LexErrors is a list of the errors a lexer recovered from.
+type LexErrors []*LexError
+
+// Error returns the errors one per line.
+func (e LexErrors) Error() string {
+	messages := make([]string, len(e))
+	for idx, err := range e {
+		messages[idx] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// newError creates a LexError describing the range start:end of the source.
+// Line directives apply to the file name and line numbers but not the offsets.
+func (l *Lexer) newError(start, end int, msg string, args ...interface{}) *LexError {
+	filename, startLine := l.mappedLocation(start)
+	_, endLine := l.mappedLocation(end)
+	return &LexError{
+		Filename:  filename,
+		Start:     l.SourceOffset(start),
+		End:       l.SourceOffset(end),
+		Pos:       l.pos(start),
+		StartLine: startLine,
+		StartChar: l.CharNo(start),
+		EndLine:   endLine,
+		EndChar:   l.CharNo(end),
+		Message:   fmt.Sprintf(msg, args...),
+
+		IncludedFrom: l.includedFrom(l.file),
+	}
+}
+
+// SetRecovery enables or disables recovery mode. Normally, the lexer panics
+// with a *LexError when it encounters a problem. In recovery mode, the error
+// is recorded instead, the offending text is returned as an ErrorToken and
+// lexing resumes after it.
+func (l *Lexer) SetRecovery(enabled bool) {
+	l.recovering = enabled
+}
+
+// Errors returns the errors recorded in recovery mode.
+func (l *Lexer) Errors() []*LexError {
+	return l.errors
+}
+
+// Err returns the errors recorded in recovery mode as LexErrors, or nil if
+// there were none.
+func (l *Lexer) Err() error {
+	if len(l.errors) == 0 {
+		return nil
+	}
+	return LexErrors(l.errors)
+}
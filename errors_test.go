@@ -0,0 +1,128 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/kfsone/parsing/lib/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLexError_Error(t *testing.T) {
+	err := &LexError{Filename: "a.txt", Start: 4, End: 5, StartLine: 2, StartChar: 3, EndLine: 2, EndChar: 4, Message: "bad"}
+	if assert.Equal(t, "a.txt:2:3: error: bad", err.Error()) {
+		err.End, err.EndLine, err.EndChar = 9, 3, 1
+		assert.Equal(t, "a.txt:2:3-3:1: error: bad", err.Error())
+	}
+}
+
+func TestLexErrors_Error(t *testing.T) {
+	errs := LexErrors{
+		{Filename: "a", StartLine: 1, StartChar: 1, Message: "one"},
+		{Filename: "b", StartLine: 2, StartChar: 2, Message: "two"},
+	}
+	assert.Equal(t, "a:1:1: error: one\nb:2:2: error: two", errs.Error())
+}
+
+func TestLexer_newError(t *testing.T) {
+	l := NewLexer("new.test", []byte("ab\ncdef\ng"))
+	err := l.newError(4, 8, "%s %d", "went", 42)
+	assert.Equal(t, &LexError{
+		Filename:  "new.test",
+		Start:     4,
+		End:       8,
+		StartLine: 2,
+		StartChar: 2,
+		EndLine:   3,
+		EndChar:   1,
+		Message:   "went 42",
+	}, err)
+}
+
+func TestLexer_panics(t *testing.T) {
+	l := NewLexer("panic.test", []byte("x 'unterminated\ny"))
+	defer func() {
+		err, ok := recover().(*LexError)
+		if assert.True(t, ok) {
+			assert.Equal(t, "panic.test:1:3-1:16: error: unterminated string/missing close-quote?", err.Error())
+		}
+	}()
+	for l.Advance() {
+	}
+	t.Error("expected a panic")
+}
+
+func TestLexer_SetRecovery(t *testing.T) {
+	lex := func(code string) (l *Lexer, tokens []Token, values []string) {
+		l = NewLexer("recover.test", []byte(code))
+		l.SetRecovery(true)
+		for l.Advance() {
+			tokens = append(tokens, l.Token)
+			values = append(values, l.String())
+		}
+		return
+	}
+
+	t.Run("no errors", func(t *testing.T) {
+		l, _, _ := lex("a 'b' c")
+		assert.Empty(t, l.Errors())
+		assert.Nil(t, l.Err())
+	})
+
+	t.Run("strings", func(t *testing.T) {
+		l, tokens, values := lex("a 'b\nc \"d\ne")
+		assert.Equal(t, []Token{
+			IdentifierToken, WhitespaceToken, ErrorToken, NewlineToken,
+			IdentifierToken, WhitespaceToken, ErrorToken, NewlineToken, IdentifierToken,
+		}, tokens)
+		assert.Equal(t, []string{"a", " ", "'b", "\n", "c", " ", "\"d", "\n", "e"}, values)
+		if assert.Len(t, l.Errors(), 2) {
+			assert.Equal(t, 2, l.Errors()[0].Start)
+			assert.Equal(t, 4, l.Errors()[0].End)
+			assert.Equal(t, 2, l.Errors()[1].StartLine)
+		}
+		assert.Equal(t,
+			"recover.test:1:3-1:5: error: unterminated string/missing close-quote?\n"+
+				"recover.test:2:3-2:5: error: unterminated string/missing close-quote?",
+			l.Err().Error())
+	})
+
+	t.Run("comment", func(t *testing.T) {
+		l, tokens, values := lex("a /* b\n")
+		assert.Equal(t, []Token{IdentifierToken, WhitespaceToken, ErrorToken}, tokens)
+		assert.Equal(t, "/* b\n", values[2])
+		assert.Len(t, l.Errors(), 1)
+	})
+
+	t.Run("utf-8", func(t *testing.T) {
+		l := NewLexer("recover.test", []byte("a\xffb 'c\xffd'"))
+		l.SetUnicode(true)
+		l.SetRecovery(true)
+		var tokens []Token
+		for l.Advance() {
+			tokens = append(tokens, l.Token)
+		}
+		assert.Equal(t, []Token{IdentifierToken, ErrorToken, IdentifierToken, WhitespaceToken, ErrorToken}, tokens)
+		if assert.Len(t, l.Errors(), 2) {
+			assert.Equal(t, 1, l.Errors()[0].Start)
+			assert.Equal(t, 6, l.Errors()[1].Start)
+		}
+	})
+}
+
+func Test_parseFile(t *testing.T) {
+	t.Run("recovers LexError", func(t *testing.T) {
+		before := stats.FetchCounter("errors")
+		require.NotPanics(t, func() {
+			parseFile(func(path string) {
+				NewParser(NewLexer(path, []byte("/* unterminated")))
+			}, "bad.file")
+		})
+		assert.Equal(t, before+1, stats.FetchCounter("errors"))
+	})
+	t.Run("propagates other panics", func(t *testing.T) {
+		assert.PanicsWithValue(t, "other", func() {
+			parseFile(func(string) { panic("other") }, "file")
+		})
+	})
+}
@@ -0,0 +1,200 @@
+package parsing
+
+import (
+	"bytes"
+	"go/token"
+	"sort"
+	"sync"
+)
+
+// Pos is a compact position in the sources of a FileSet: the base of a file
+// plus a byte offset within it, like go/token.Pos. Symbols and LexErrors of a
+// lexer with a FileSet carry their Pos, so that they can be located without
+// the lexer that produced them.
+type Pos int
+
+// NoPos is the zero Pos, which isn't in any file.
+const NoPos Pos = 0
+
+// IsValid returns true if the position isn't NoPos.
+func (p Pos) IsValid() bool { return p != NoPos }
+
+// FileSet assigns each source registered with it a distinct range of Pos
+// values, so that a single Pos identifies a file, line and column. FileSets
+// may be shared by lexers in different goroutines.
+type FileSet struct {
+	mutex sync.RWMutex
+	base  int     // base of the next file
+	files []*File // in order of base
+	last  *File   // most recently resolved file
+
+	interned internTable // identifiers and keywords of the set's lexers
+}
+
+// File is a source registered with a FileSet.
+type File struct {
+	name     string
+	base     int
+	size     int
+	lines    []int // offsets of the start of each line
+	mutex    sync.RWMutex
+	lineInfo []lineMapping // see AddLineInfo
+}
+
+// NewFileSet returns an empty FileSet.
+func NewFileSet() *FileSet {
+	return &FileSet{base: 1, interned: internTable{shared: true, strings: make(map[string]string)}}
+}
+
+// AddFile registers a source with the set and returns its File. The offsets
+// of the source, from 0 to len(content) inclusive, map to the Pos values
+// from the file's base upwards.
+func (s *FileSet) AddFile(name string, content []byte) *File {
+	lines := []int{0}
+	for offset := 0; ; {
+		idx := bytes.IndexByte(content[offset:], '\n')
+		if idx < 0 {
+			break
+		}
+		offset += idx + 1
+		lines = append(lines, offset)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file := &File{name: name, base: s.base, size: len(content), lines: lines}
+	// Leave room for the position at the end of the file.
+	s.base += len(content) + 1
+	s.files = append(s.files, file)
+	return file
+}
+
+// File returns the file containing a position, or nil if there isn't one.
+func (s *FileSet) File(p Pos) *File {
+	s.mutex.RLock()
+	last := s.last
+	s.mutex.RUnlock()
+	if last != nil && last.contains(p) {
+		return last
+	}
+
+	s.mutex.RLock()
+	idx := sort.Search(len(s.files), func(i int) bool { return s.files[i].base > int(p) }) - 1
+	var file *File
+	if idx >= 0 && s.files[idx].contains(p) {
+		file = s.files[idx]
+	}
+	s.mutex.RUnlock()
+
+	if file != nil {
+		s.mutex.Lock()
+		s.last = file
+		s.mutex.Unlock()
+	}
+	return file
+}
+
+// Position describes a position as a go/token.Position, so that it can be
+// used with Go tooling, adjusted by any line directives, see PositionFor.
+func (s *FileSet) Position(p Pos) token.Position {
+	return s.PositionFor(p, true)
+}
+
+// PositionFor describes a position as a go/token.Position, adjusted by any
+// line directives if adjusted is true. The zero Position is returned for
+// positions that aren't in the set.
+func (s *FileSet) PositionFor(p Pos, adjusted bool) token.Position {
+	if file := s.File(p); file != nil {
+		return file.PositionFor(p, adjusted)
+	}
+	return token.Position{}
+}
+
+// Name returns the name of the file.
+func (f *File) Name() string { return f.name }
+
+// Base returns the Pos of the start of the file.
+func (f *File) Base() int { return f.base }
+
+// Size returns the length of the file in bytes.
+func (f *File) Size() int { return f.size }
+
+// LineCount returns the number of lines in the file.
+func (f *File) LineCount() int { return len(f.lines) }
+
+// Pos returns the position of an offset within the file. Panics if the
+// offset is beyond the end of the file.
+func (f *File) Pos(offset int) Pos {
+	if offset < 0 || offset > f.size {
+		panic("offset out of range of file")
+	}
+	return Pos(f.base + offset)
+}
+
+// Offset returns the offset within the file of a position. Panics if the
+// position isn't in the file.
+func (f *File) Offset(p Pos) int {
+	if !f.contains(p) {
+		panic("position not in file")
+	}
+	return int(p) - f.base
+}
+
+// AddLineInfo records that the line beginning at offset is line line of
+// filename, as described by a line directive, like go/token.File.AddLineInfo.
+// Offsets must be added in increasing order.
+func (f *File) AddLineInfo(offset int, filename string, line int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	rawLine := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset })
+	f.lineInfo = append(f.lineInfo, lineMapping{offset: offset, rawLine: rawLine, file: filename, line: line})
+}
+
+// Position describes a position within the file as a go/token.Position,
+// with 1-based line and column numbers, adjusted by any line directives.
+func (f *File) Position(p Pos) token.Position {
+	return f.PositionFor(p, true)
+}
+
+// PositionFor describes a position within the file as a go/token.Position,
+// adjusted by any line directives if adjusted is true.
+func (f *File) PositionFor(p Pos, adjusted bool) token.Position {
+	offset := f.Offset(p)
+	line := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	position := token.Position{Filename: f.name, Offset: offset, Line: line + 1, Column: offset - f.lines[line] + 1}
+	if adjusted {
+		f.mutex.RLock()
+		position.Filename, position.Line = mapLine(f.lineInfo, f.name, line+1, offset)
+		f.mutex.RUnlock()
+	}
+	return position
+}
+
+// contains returns true if the position is within the file.
+func (f *File) contains(p Pos) bool {
+	return int(p) >= f.base && int(p) <= f.base+f.size
+}
+
+// SetFileSet registers the lexer's source with a FileSet, and any files it
+// includes as they are reached, so that the Symbols and LexErrors it produces
+// carry a Pos. Lexers sharing a FileSet also share their interned
+// identifiers and keywords, see SetInterning. Panics for streaming lexers,
+// whose size isn't known.
+func (l *Lexer) SetFileSet(set *FileSet) {
+	if l.reader != nil {
+		panic("SetFileSet requires a lexer with an in-memory source")
+	}
+	l.fileSet, l.interned = set, &set.interned
+	l.setFile = set.AddFile(l.name, l.code)
+}
+
+// FileSet returns the FileSet the lexer registers its sources with, or nil.
+func (l *Lexer) FileSet() *FileSet { return l.fileSet }
+
+// pos returns the Pos of an offset in the file being lexed, or NoPos if the
+// lexer doesn't have a FileSet.
+func (l *Lexer) pos(offset int) Pos {
+	if l.setFile == nil {
+		return NoPos
+	}
+	return l.setFile.Pos(offset)
+}
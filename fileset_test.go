@@ -0,0 +1,96 @@
+package parsing
+
+import (
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSet(t *testing.T) {
+	set := NewFileSet()
+	first := set.AddFile("first.test", []byte("ab\ncd\n"))
+	second := set.AddFile("second.test", []byte("xyz"))
+	assert.Equal(t, 1, first.Base())
+	assert.Equal(t, 6, first.Size())
+	assert.Equal(t, 3, first.LineCount())
+	assert.Equal(t, 8, second.Base())
+	assert.Equal(t, "second.test", second.Name())
+
+	tests := []struct {
+		name string
+		pos  Pos
+		file *File
+		want token.Position
+	}{
+		{"no pos", NoPos, nil, token.Position{}},
+		{"start", first.Pos(0), first, token.Position{Filename: "first.test", Offset: 0, Line: 1, Column: 1}},
+		{"newline", first.Pos(2), first, token.Position{Filename: "first.test", Offset: 2, Line: 1, Column: 3}},
+		{"second line", first.Pos(4), first, token.Position{Filename: "first.test", Offset: 4, Line: 2, Column: 2}},
+		{"end", first.Pos(6), first, token.Position{Filename: "first.test", Offset: 6, Line: 3, Column: 1}},
+		{"second file", second.Pos(1), second, token.Position{Filename: "second.test", Offset: 1, Line: 1, Column: 2}},
+		{"second end", second.Pos(3), second, token.Position{Filename: "second.test", Offset: 3, Line: 1, Column: 4}},
+		{"beyond", second.Pos(3) + 1, nil, token.Position{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.pos != NoPos, tt.pos.IsValid())
+			assert.Same(t, tt.file, set.File(tt.pos))
+			assert.Equal(t, tt.want, set.Position(tt.pos))
+		})
+	}
+
+	assert.Equal(t, 4, first.Offset(first.Pos(4)))
+	assert.PanicsWithValue(t, "offset out of range of file", func() { first.Pos(7) })
+	assert.PanicsWithValue(t, "position not in file", func() { first.Offset(second.Pos(0)) })
+}
+
+func TestLexer_SetFileSet(t *testing.T) {
+	set := NewFileSet()
+	files := map[string]string{
+		"main.cfg": "a\ninclude \"inc.cfg\" b",
+		"inc.cfg":  "\n  c",
+	}
+	l := includeLexer(files)
+	l.SetFileSet(set)
+	assert.Same(t, set, l.FileSet())
+	other := NewLexer("other.cfg", []byte("  d"))
+	other.SetFileSet(set)
+
+	var locations []string
+	for _, lexer := range []*Lexer{l, other} {
+		for {
+			symbol, _ := lexer.NextSymbol()
+			if symbol.Token == EOFToken {
+				break
+			}
+			require.True(t, symbol.Pos.IsValid())
+			locations = append(locations, symbol.Value+"@"+set.Position(symbol.Pos).String())
+		}
+	}
+	assert.Equal(t, []string{"a@main.cfg:1:1", "c@inc.cfg:2:3", "b@main.cfg:2:19", "d@other.cfg:1:3"}, locations)
+
+	t.Run("errors", func(t *testing.T) {
+		l := NewLexer("error.cfg", []byte("ok\n  \"unterminated"))
+		l.SetFileSet(set)
+		l.SetRecovery(true)
+		for l.Advance() {
+		}
+		require.Len(t, l.Errors(), 1)
+		assert.Equal(t, "error.cfg:2:3", set.Position(l.Errors()[0].Pos).String())
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		l := NewReaderLexer("stream.cfg", strings.NewReader("x"))
+		assert.PanicsWithValue(t, "SetFileSet requires a lexer with an in-memory source", func() { l.SetFileSet(set) })
+	})
+
+	t.Run("without", func(t *testing.T) {
+		l := NewLexer("plain.cfg", []byte("x"))
+		require.True(t, l.Advance())
+		assert.Equal(t, NoPos, l.symbol(nil).Pos)
+		assert.Nil(t, l.FileSet())
+	})
+}
@@ -0,0 +1,268 @@
+package parsing
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxIncludeDepth limits the nesting of included files when
+// IncludeOptions.MaxDepth is 0.
+const DefaultMaxIncludeDepth = 32
+
+// IncludeOptions describes how a Lexer finds included files, see SetIncludes.
+type IncludeOptions struct {
+	// Directive is the text that introduces an include, such as "include" or
+	// "#include", and must be followed by a quoted file name. The directive
+	// is returned as a DirectiveToken. If empty, files are only included by
+	// calling Include.
+	Directive string
+	// Paths are searched, in order, for files that aren't found relative to
+	// the directory of the including file.
+	Paths []string
+	// MaxDepth limits how deeply includes may be nested, or
+	// DefaultMaxIncludeDepth if 0.
+	MaxDepth int
+	// ReadFile loads an included file, ioutil.ReadFile if nil. It should
+	// return an error satisfying os.IsNotExist for files that don't exist.
+	ReadFile func(path string) ([]byte, error)
+}
+
+// sourceFile describes an included file that Symbols were lexed from.
+type sourceFile struct {
+	name      string
+	code      []byte
+	offsets   *offsetMap
+	lines     []int         // offsets of the start of each line
+	parent    *sourceFile   // file containing the include, nil for the lexer's main file
+	at        int           // offset of the include in parent
+	including includeFrame  // state of parent while the file is lexed, see Reset
+	setFile   *File         // the file in the lexer's FileSet
+	lineMap   []lineMapping // line directives, see AddLineDirective
+}
+
+// includeFrame saves the state of a file while a file it includes is lexed.
+type includeFrame struct {
+	file    *sourceFile
+	name    string
+	code    []byte
+	base    int
+	reader  io.Reader
+	offsets *offsetMap
+	retain  int
+	lines   []int
+	indexed int
+	end     int
+	indent  *indentState
+	setFile *File
+}
+
+// pendingInclude is a file that begins at the next call to Advance.
+type pendingInclude struct {
+	name string
+	code []byte
+	at   int
+}
+
+// SetIncludes enables include directives and configures how included files
+// are found. Included files are lexed transparently: Advance returns their
+// tokens in place of the directive and then continues with the including
+// file, and Filename, LineNo etc describe the file being lexed. Errors in an
+// included file list the includes through which it was reached.
+func (l *Lexer) SetIncludes(options IncludeOptions) {
+	l.includeOptions = &options
+}
+
+// Include splices a file into the source so that the next call to Advance
+// returns its first token, returning to the current file at its end. The file
+// is resolved relative to the directory of the current file and then each of
+// the search paths, see SetIncludes.
+func (l *Lexer) Include(filename string) error {
+	return l.includeAt(filename, l.Start)
+}
+
+// IncludeDepth returns the number of included files currently being lexed,
+// 0 in the main file.
+func (l *Lexer) IncludeDepth() int {
+	return len(l.includes)
+}
+
+// includeAt schedules an include of filename by a directive at offset at.
+func (l *Lexer) includeAt(filename string, at int) error {
+	options := l.includeOptions
+	if options == nil {
+		options = &IncludeOptions{}
+	}
+	maxDepth := options.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxIncludeDepth
+	}
+	if len(l.includes) >= maxDepth {
+		return fmt.Errorf("includes nested too deeply including %q (limit %d)", filename, maxDepth)
+	}
+	path, code, err := options.resolve(filename, l.name)
+	if err != nil {
+		return err
+	}
+
+	chain := make([]string, 0, len(l.includes)+2)
+	for _, frame := range l.includes {
+		chain = append(chain, frame.name)
+	}
+	chain = append(chain, l.name)
+	for _, name := range chain {
+		if filepath.Clean(name) == path {
+			return fmt.Errorf("include cycle: %s", strings.Join(append(chain, path), " -> "))
+		}
+	}
+	l.pending = &pendingInclude{name: path, code: code, at: at}
+	return nil
+}
+
+// resolve finds and loads an included file.
+func (o *IncludeOptions) resolve(filename, includer string) (path string, code []byte, err error) {
+	readFile := o.ReadFile
+	if readFile == nil {
+		readFile = ioutil.ReadFile
+	}
+	candidates := []string{filename}
+	if !filepath.IsAbs(filename) {
+		candidates[0] = filepath.Join(filepath.Dir(includer), filename)
+		for _, dir := range o.Paths {
+			candidates = append(candidates, filepath.Join(dir, filename))
+		}
+	}
+	for _, candidate := range candidates {
+		code, err = readFile(candidate)
+		if err == nil {
+			return filepath.Clean(candidate), code, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", nil, err
+		}
+	}
+	return "", nil, fmt.Errorf("cannot find include file %q", filename)
+}
+
+// includeDirective recognizes an include directive at the current token and
+// replaces it with a DirectiveToken, scheduling the file it names.
+func (l *Lexer) includeDirective() bool {
+	directive := l.includeOptions.Directive
+	start, end := l.Start, l.Start+len(directive)
+	if l.End == l.Start || !l.hasPrefix(start, directive) {
+		return false
+	}
+	// "include" mustn't match the start of "included".
+	if char, ok := l.byteAt(end); ok && l.Dialect().IsIdentifierContinuation(directive[len(directive)-1]) &&
+		l.Dialect().IsIdentifierContinuation(char) {
+		return false
+	}
+
+	l.End = end
+	for l.advance() && l.Token == WhitespaceToken {
+	}
+	if l.Token != StringToken {
+		l.fatalAt(start, l.End, "%s must be followed by a quoted file name", directive)
+		l.Start = start
+		return true
+	}
+	symbol := l.symbol(nil)
+	filename, err := symbol.Unquote()
+	l.Start, l.Token = start, DirectiveToken
+	if escapeErr, ok := err.(*EscapeError); ok {
+		l.fatalAt(escapeErr.Offset, escapeErr.Offset+escapeErr.Length, "%s", escapeErr.Message)
+	} else if err = l.includeAt(filename, start); err != nil {
+		l.fatalAt(start, l.End, "%s", err)
+	}
+	return true
+}
+
+// beginInclude switches the lexer to a pending included file.
+func (l *Lexer) beginInclude() {
+	pending := l.pending
+	l.pending = nil
+	// Symbols of the including file are located through its line index.
+	l.indexLines(l.End)
+	including := l.frame()
+	l.includes = append(l.includes, including)
+	including.indent = including.indent.copy()
+	l.name, l.base, l.reader, l.retain = pending.name, 0, nil, 0
+	l.code, l.offsets = decodeSource(pending.code)
+	l.lines, l.indexed = nil, 0
+	l.indexLines(len(l.code))
+	if l.fileSet != nil {
+		l.setFile = l.fileSet.AddFile(pending.name, l.code)
+	}
+	l.file = &sourceFile{name: pending.name, code: l.code, offsets: l.offsets, lines: l.lines, parent: l.file,
+		at: pending.at, including: including, setFile: l.setFile}
+	l.Start, l.End = 0, 0
+	if l.indent != nil {
+		l.SetIndentation(true)
+	}
+}
+
+// endInclude returns the lexer to the file containing the current include.
+func (l *Lexer) endInclude() {
+	frame := l.includes[len(l.includes)-1]
+	l.includes = l.includes[:len(l.includes)-1]
+	l.restoreFrame(frame)
+}
+
+// frame captures the state of the file being lexed.
+func (l *Lexer) frame() includeFrame {
+	return includeFrame{
+		file:    l.file,
+		name:    l.name,
+		code:    l.code,
+		base:    l.base,
+		reader:  l.reader,
+		offsets: l.offsets,
+		retain:  l.retain,
+		lines:   l.lines,
+		indexed: l.indexed,
+		end:     l.End,
+		indent:  l.indent,
+		setFile: l.setFile,
+	}
+}
+
+// restoreFrame returns the lexer to a file captured by frame.
+func (l *Lexer) restoreFrame(frame includeFrame) {
+	l.file, l.name, l.code, l.base, l.reader, l.retain = frame.file, frame.name, frame.code, frame.base, frame.reader, frame.retain
+	l.offsets = frame.offsets
+	l.lines, l.indexed, l.indent, l.setFile = frame.lines, frame.indexed, frame.indent, frame.setFile
+	l.Start, l.End = frame.end, frame.end
+}
+
+// locate describes the position of offset within the file origin, or the
+// lexer's main file if origin is nil, as "file:line:char", after applying any
+// line directives.
+func (l *Lexer) locate(origin *sourceFile, offset int) string {
+	name, lines := "", []int(nil)
+	switch {
+	case origin == l.file:
+		file, line := l.mappedLocation(offset)
+		return fmt.Sprintf("%s:%d:%d", file, line, l.CharNo(offset))
+	case origin == nil:
+		name, lines = l.includes[0].name, l.includes[0].lines
+	default:
+		name, lines = origin.name, origin.lines
+	}
+	line := sort.Search(len(lines), func(i int) bool { return lines[i] > offset }) - 1
+	file, lineNo := mapLine(l.lineMapOf(origin), name, line+1, offset)
+	return fmt.Sprintf("%s:%d:%d", file, lineNo, offset-lines[line]+1)
+}
+
+// includedFrom returns the locations of the includes through which origin
+// was reached, innermost first.
+func (l *Lexer) includedFrom(origin *sourceFile) (locations []string) {
+	for file := origin; file != nil; file = file.parent {
+		locations = append(locations, l.locate(file.parent, file.at))
+	}
+	return locations
+}
@@ -0,0 +1,187 @@
+package parsing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fileMap returns a ReadFile function that serves files from memory.
+func fileMap(files map[string]string) func(string) ([]byte, error) {
+	return func(path string) ([]byte, error) {
+		if code, ok := files[filepath.ToSlash(path)]; ok {
+			return []byte(code), nil
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+}
+
+// includeLexer returns a lexer for main.cfg with the given files available
+// to include.
+func includeLexer(files map[string]string, paths ...string) *Lexer {
+	l := NewLexer("main.cfg", []byte(files["main.cfg"]))
+	l.SetIncludes(IncludeOptions{Directive: "include", Paths: paths, ReadFile: fileMap(files)})
+	return l
+}
+
+func TestLexer_SetIncludes(t *testing.T) {
+	files := map[string]string{
+		"main.cfg":       "a\ninclude \"b.cfg\"\nc include \"lib.cfg\" d\nincluded",
+		"b.cfg":          "b1 b2\ninclude \"sub/c.cfg\"",
+		"sub/c.cfg":      "include \"d.cfg\"",
+		"sub/d.cfg":      "d1",
+		"lib/lib.cfg":    "lib",
+		"unused/lib.cfg": "unused",
+	}
+	l := includeLexer(files, "lib", "unused")
+	var values, names []string
+	depths := map[string]int{}
+	for l.Advance() {
+		if IsSignificant(l.Token) {
+			values = append(values, l.String())
+			names = append(names, l.Filename())
+			depths[l.String()] = l.IncludeDepth()
+		}
+	}
+	assert.Equal(t, []string{"a", "b1", "b2", "d1", "c", "lib", "d", "included"}, values)
+	assert.Equal(t, []string{"main.cfg", "b.cfg", "b.cfg", filepath.FromSlash("sub/d.cfg"), "main.cfg",
+		filepath.FromSlash("lib/lib.cfg"), "main.cfg", "main.cfg"}, names)
+	assert.Equal(t, map[string]int{"a": 0, "b1": 1, "b2": 1, "d1": 3, "c": 0, "lib": 1, "d": 0, "included": 0}, depths)
+}
+
+func TestLexer_Include(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.cfg"), []byte("other"), 0o600))
+
+	l := NewLexer(filepath.Join(dir, "main.cfg"), []byte("first second"))
+	require.True(t, l.Advance())
+	assert.NoError(t, l.Include("other.cfg"))
+	var values []string
+	for l.Advance() {
+		values = append(values, l.String())
+	}
+	assert.Equal(t, []string{"other", " ", "second"}, values)
+
+	assert.EqualError(t, l.Include("missing.cfg"), `cannot find include file "missing.cfg"`)
+}
+
+func TestLexer_includeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{"missing", map[string]string{"main.cfg": "include \"nope.cfg\""},
+			`main.cfg:1:1-1:19: error: cannot find include file "nope.cfg"`},
+		{"no file name", map[string]string{"main.cfg": "include 42"},
+			"main.cfg:1:1-1:11: error: include must be followed by a quoted file name"},
+		{"bad escape", map[string]string{"main.cfg": `include "a\q"`},
+			`main.cfg:1:11-1:13: error: unknown escape sequence \q`},
+		{"self", map[string]string{"main.cfg": "include \"main.cfg\""},
+			"main.cfg:1:1-1:19: error: include cycle: main.cfg -> main.cfg"},
+		{"cycle", map[string]string{"main.cfg": "include \"a.cfg\"", "a.cfg": "\ninclude \"main.cfg\""},
+			"a.cfg:2:1-2:19: error: include cycle: main.cfg -> a.cfg -> main.cfg\n\tincluded from main.cfg:1:1"},
+		{"depth", map[string]string{"main.cfg": "include \"a.cfg\"", "a.cfg": "include \"b.cfg\"", "b.cfg": "include \"c.cfg\"", "c.cfg": ""},
+			"b.cfg:1:1-1:16: error: includes nested too deeply including \"c.cfg\" (limit 2)\n\tincluded from a.cfg:1:1\n\tincluded from main.cfg:1:1"},
+		{"in included file", map[string]string{"main.cfg": "x\n  include \"a.cfg\"", "a.cfg": "ok\n'unterminated"},
+			"a.cfg:2:1-2:14: error: unterminated string/missing close-quote?\n\tincluded from main.cfg:2:3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := includeLexer(tt.files)
+			l.includeOptions.MaxDepth = 2
+			assert.PanicsWithError(t, tt.err, func() {
+				for l.Advance() {
+				}
+			})
+		})
+	}
+
+	t.Run("recovery", func(t *testing.T) {
+		l := includeLexer(map[string]string{"main.cfg": "a include \"nope.cfg\" b"})
+		l.SetRecovery(true)
+		tokens, err := Tokenize(l, false)
+		assert.EqualError(t, err, `main.cfg:1:3-1:21: error: cannot find include file "nope.cfg"`)
+		assert.Equal(t, []string{`"a"`, "ERROR", `"b"`}, identities(tokens))
+	})
+}
+
+// identities returns the Identity of each symbol.
+func identities(symbols []Symbol) (result []string) {
+	for _, symbol := range symbols {
+		result = append(result, symbol.Identity())
+	}
+	return result
+}
+
+func TestLexer_includeDirective(t *testing.T) {
+	files := map[string]string{"main.cfg": "#include \"b.cfg\"\n#includes", "b.cfg": "b"}
+	l := NewLexer("main.cfg", []byte(files["main.cfg"]))
+	l.SetIncludes(IncludeOptions{Directive: "#include", ReadFile: fileMap(files)})
+	tokens, err := Tokenize(l, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DIRECTIVE", `"b"`, "NEWLINE", `SYMBOL "#"`, `"includes"`}, identities(tokens))
+	assert.Equal(t, `#include "b.cfg"`, tokens[0].Value)
+}
+
+func TestLexer_includeIndentation(t *testing.T) {
+	files := map[string]string{"main.cfg": "if x:\n    include \"body.cfg\"\n    y\nz\n", "body.cfg": "a\nif b:\n  c\n"}
+	l := includeLexer(files)
+	l.SetIndentation(true)
+	tokens, err := Tokenize(l, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`"if"`, `"x"`, `colon (":")`, "EOL", "INDENT",
+		`"a"`, "EOL", `"if"`, `"b"`, `colon (":")`, "EOL", "INDENT", `"c"`, "EOL", "DEDENT",
+		`"y"`, "EOL", "DEDENT", `"z"`, "EOL"}, identities(tokens))
+}
+
+func TestLexer_includeMark(t *testing.T) {
+	l := includeLexer(map[string]string{"main.cfg": "include \"b.cfg\" a", "b.cfg": "b"})
+	mark := l.Mark()
+	require.True(t, l.Advance())
+	assert.Equal(t, DirectiveToken, l.Token)
+	l.Reset(mark)
+	require.True(t, l.Advance())
+	require.True(t, l.Advance())
+	assert.Equal(t, "b", l.String())
+
+	// Marks may be returned to from other files.
+	inside := l.Mark()
+	l.Reset(mark)
+	assert.Equal(t, 0, l.IncludeDepth())
+	_, values := lexTokens(l)
+	assert.Equal(t, []string{"b", "a"}, values)
+	l.Reset(inside)
+	assert.Equal(t, 1, l.IncludeDepth())
+	assert.Equal(t, "b", l.String())
+	_, values = lexTokens(l)
+	assert.Equal(t, []string{"a"}, values)
+}
+
+func TestParser_include(t *testing.T) {
+	files := map[string]string{
+		"main.cfg":  "first\ninclude \"inc/b.cfg\"\nlast",
+		"inc/b.cfg": "\n  second \"bad\\q\"",
+	}
+	p := NewParser(includeLexer(files))
+	var locations []string
+	for ; !p.EOF(); p.Next() {
+		locations = append(locations, p.Locate(p.Current()))
+	}
+	assert.Equal(t, []string{"main.cfg:1:1", filepath.FromSlash("inc/b.cfg") + ":2:3", filepath.FromSlash("inc/b.cfg") + ":2:10", "main.cfg:3:1"}, locations)
+
+	p = NewParser(includeLexer(files))
+	p.Next()
+	second := p.Current()
+	assert.EqualError(t, p.Errorf(second, "oops"), filepath.FromSlash("inc/b.cfg")+":2:3: oops: \"second\"\n\tincluded from main.cfg:2:1")
+	p.Next()
+	_, err := p.Unquote(p.Current())
+	assert.EqualError(t, err, filepath.FromSlash("inc/b.cfg")+`:2:14: unknown escape sequence \q`)
+	p.Next()
+	assert.Equal(t, "main.cfg:3:1", p.Locate(p.Current()))
+	assert.EqualError(t, p.Errorf(p.Current(), "oops"), `main.cfg:3:1: oops: "last"`)
+}
@@ -0,0 +1,169 @@
+package parsing
+
+import "strings"
+
+// Indentation mode, for languages such as Python or YAML where indentation is
+// structure. When a line is indented further than the one before, the lexer
+// returns an IndentToken before its first token; when it returns to an outer
+// level, a DedentToken for each level closed. The newline at the end of a
+// line with significant tokens becomes an EOLToken, which is significant,
+// while blank lines and lines containing only comments remain trivia.
+//
+// Inside (parentheses), [brackets] or {braces}, lines are joined and the
+// indentation is not tracked. At end-of-file, a final EOLToken is returned if
+// the last line had no newline, followed by DedentTokens for any open levels.
+//
+// INDENT and DEDENT tokens are zero-width: Start == End.
+
+// indentState tracks the indentation of a Lexer in indentation mode.
+type indentState struct {
+	levels      []string // indentation of each open level, starting with ""
+	nesting     int      // depth of brackets
+	atLineStart bool     // the next token begins a line
+	content     bool     // the current line has significant tokens
+	lineIndent  string   // indentation of the current line
+	indentStart int      // offset of the current line's indentation
+	dedents     int      // DEDENT tokens still to be returned
+}
+
+// copy returns a copy of the state that doesn't share its levels.
+func (s *indentState) copy() *indentState {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.levels = append([]string(nil), s.levels...)
+	return &c
+}
+
+// SetIndentation determines whether lexers for this dialect start in
+// indentation mode, see Lexer.SetIndentation.
+func (d *Dialect) SetIndentation(enabled bool) {
+	d.indentation = enabled
+}
+
+// SetIndentation enables or disables indentation mode, in which the lexer
+// returns IndentToken, DedentToken and EOLToken to describe the structure of
+// the source's indentation. Enable it before the first call to Advance.
+func (l *Lexer) SetIndentation(enabled bool) {
+	l.indent = nil
+	if enabled {
+		l.indent = &indentState{levels: []string{""}, atLineStart: true, indentStart: l.End}
+	}
+}
+
+// advanceIndented wraps advance with indentation tracking.
+func (l *Lexer) advanceIndented() bool {
+	state := l.indent
+	if state.dedents > 0 {
+		state.dedents--
+		l.Start, l.Token = l.End, DedentToken
+		return true
+	}
+
+	// The first significant token of a line may need to be preceded by an
+	// INDENT or DEDENT, in which case it is lexed again afterwards.
+	lineStart := state.atLineStart && state.nesting == 0
+	var mark LexerMark
+	if lineStart {
+		mark = l.Mark()
+		defer l.Commit(mark)
+	}
+	if !l.advance() {
+		return l.endIndentation()
+	}
+
+	switch {
+	case !lineStart:
+	case l.Token == WhitespaceToken:
+		state.lineIndent, state.indentStart = l.String(), l.Start
+		return true
+	case IsSignificant(l.Token):
+		state.atLineStart = false
+		if token, dedents := l.changeIndentation(); token != InvalidToken {
+			errors := l.errors
+			l.Reset(mark)
+			// Reset also rewound the indentation state and any error.
+			l.indent, l.errors, state.dedents = state, errors, dedents
+			l.Start, l.Token = l.End, token
+			return true
+		}
+	}
+
+	switch l.Token {
+	case OpenParen, OpenBracket, OpenBrace:
+		state.nesting++
+	case CloseParen, CloseBracket, CloseBrace:
+		if state.nesting > 0 {
+			state.nesting--
+		}
+	case NewlineToken:
+		if state.nesting == 0 {
+			state.atLineStart, state.lineIndent, state.indentStart = true, "", l.End
+			if state.content {
+				state.content = false
+				l.Token = EOLToken
+			}
+		}
+		return true
+	}
+	if IsSignificant(l.Token) {
+		state.content = true
+	}
+	return true
+}
+
+// changeIndentation compares the indentation of the current line with the
+// open levels. Returns the token to insert before the line's first token, if
+// any, and the number of further DEDENTs. In recovery mode, inconsistent
+// indentation is reported and an ErrorToken inserted.
+func (l *Lexer) changeIndentation() (Token, int) {
+	state := l.indent
+	current := state.levels[len(state.levels)-1]
+	switch {
+	case state.lineIndent == current:
+		return InvalidToken, 0
+
+	case strings.HasPrefix(state.lineIndent, current):
+		state.levels = append(state.levels, state.lineIndent)
+		return IndentToken, 0
+
+	case strings.HasPrefix(current, state.lineIndent):
+		dedents := 0
+		for len(state.levels) > 1 && len(state.levels[len(state.levels)-1]) > len(state.lineIndent) {
+			state.levels = state.levels[:len(state.levels)-1]
+			dedents++
+		}
+		if state.levels[len(state.levels)-1] != state.lineIndent {
+			l.indentationError("unindent does not match any outer indentation level")
+			// Treat the line as opening a new level, to limit further errors.
+			state.levels = append(state.levels, state.lineIndent)
+		}
+		return DedentToken, dedents - 1
+	}
+	l.indentationError("inconsistent use of tabs and spaces in indentation")
+	return ErrorToken, 0
+}
+
+// indentationError reports an error at the current line's indentation.
+func (l *Lexer) indentationError(msg string) {
+	state := l.indent
+	l.fatalAt(state.indentStart, state.indentStart+len(state.lineIndent), "%s", msg)
+}
+
+// endIndentation completes the last line and closes any open levels at EOF.
+func (l *Lexer) endIndentation() bool {
+	state := l.indent
+	switch {
+	case state.content:
+		state.content = false
+		l.Token = EOLToken
+		return true
+	case len(state.levels) > 1:
+		state.dedents = len(state.levels) - 2
+		state.levels = state.levels[:1]
+		l.Token = DedentToken
+		return true
+	}
+	return false
+}
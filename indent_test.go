@@ -0,0 +1,143 @@
+package parsing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// indentTokens returns the Identity of each significant token of code lexed
+// in indentation mode.
+func indentTokens(t *testing.T, code string) (identities []string) {
+	l := NewLexer("indent.test", []byte(code))
+	l.SetIndentation(true)
+	tokens, err := Tokenize(l, false)
+	require.NoError(t, err)
+	for _, symbol := range tokens {
+		identities = append(identities, symbol.Identity())
+	}
+	return identities
+}
+
+func TestLexer_SetIndentation(t *testing.T) {
+	l := NewLexer("indent.test", nil)
+	assert.Nil(t, l.indent)
+	l.SetIndentation(true)
+	if assert.NotNil(t, l.indent) {
+		assert.Equal(t, []string{""}, l.indent.levels)
+	}
+	l.SetIndentation(false)
+	assert.Nil(t, l.indent)
+
+	d := NewDialect("python")
+	d.SetIndentation(true)
+	assert.NotNil(t, d.NewLexer("indent.test", nil).indent)
+}
+
+func TestLexer_indentation(t *testing.T) {
+	tests := []struct {
+		name, code string
+		want       []string
+	}{
+		{"flat", "a\nb\n", []string{`"a"`, "EOL", `"b"`, "EOL"}},
+		{"no final newline", "a\nb", []string{`"a"`, "EOL", `"b"`, "EOL"}},
+		{"block", "if x:\n    y\nz\n", []string{`"if"`, `"x"`, `colon (":")`, "EOL", "INDENT", `"y"`, "EOL", "DEDENT", `"z"`, "EOL"}},
+		{"nested", "a\n  b\n    c\nd\n", []string{`"a"`, "EOL", "INDENT", `"b"`, "EOL", "INDENT", `"c"`, "EOL", "DEDENT", "DEDENT", `"d"`, "EOL"}},
+		{"eof dedents", "a\n\tb\n\t\tc", []string{`"a"`, "EOL", "INDENT", `"b"`, "EOL", "INDENT", `"c"`, "EOL", "DEDENT", "DEDENT"}},
+		{"blank and comment lines", "a\n\n    // note\n  \n  b\n", []string{`"a"`, "EOL", "INDENT", `"b"`, "EOL", "DEDENT"}},
+		{"trailing comment", "a // note\n  b\n", []string{`"a"`, "EOL", "INDENT", `"b"`, "EOL", "DEDENT"}},
+		{"brackets", "f(1,\n2,\n    [3,\n4])\n  g\n", []string{`"f"`, `open-parens ("(")`, `INTEGER "1"`, `comma (",")`,
+			`INTEGER "2"`, `comma (",")`, `open-bracket ("[")`, `INTEGER "3"`, `comma (",")`, `INTEGER "4"`,
+			`close-bracket ("]")`, `close-parens (")")`, "EOL", "INDENT", `"g"`, "EOL", "DEDENT"}},
+		{"indented first line", "  a\n", []string{"INDENT", `"a"`, "EOL", "DEDENT"}},
+		{"crlf", "a\r\n  b\r\n", []string{`"a"`, "EOL", "INDENT", `"b"`, "EOL", "DEDENT"}},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, indentTokens(t, tt.code))
+		})
+	}
+}
+
+func TestLexer_indentation_zeroWidth(t *testing.T) {
+	l := NewLexer("indent.test", []byte("a\n  b\n"))
+	l.SetIndentation(true)
+	var tokens []Token
+	for l.Advance() {
+		if l.Token == IndentToken || l.Token == DedentToken {
+			assert.Equal(t, l.Start, l.End)
+		}
+		tokens = append(tokens, l.Token)
+	}
+	assert.Equal(t, []Token{IdentifierToken, EOLToken, WhitespaceToken, IndentToken, IdentifierToken, EOLToken, DedentToken}, tokens)
+}
+
+func TestLexer_indentation_errors(t *testing.T) {
+	tests := []struct {
+		name, code, err string
+	}{
+		{"tabs and spaces", "a\n\tb\n        c\n", "errors.test:3:1-3:9: error: inconsistent use of tabs and spaces in indentation"},
+		{"unindent", "a\n    b\n  c\n", "errors.test:3:1-3:3: error: unindent does not match any outer indentation level"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLexer("errors.test", []byte(tt.code))
+			l.SetIndentation(true)
+			_, err := Tokenize(l, false)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+
+	t.Run("recovery", func(t *testing.T) {
+		l := NewLexer("errors.test", []byte("a\n\tb\n        c\n    d\n\te\n"))
+		l.SetIndentation(true)
+		l.SetRecovery(true)
+		tokens, err := Tokenize(l, false)
+		assert.Error(t, err)
+		assert.Len(t, l.Errors(), 2)
+		var kinds []Token
+		for _, symbol := range tokens {
+			kinds = append(kinds, symbol.Token)
+		}
+		assert.Equal(t, []Token{IdentifierToken, EOLToken, IndentToken, IdentifierToken, EOLToken, ErrorToken, IdentifierToken, EOLToken,
+			ErrorToken, IdentifierToken, EOLToken, IdentifierToken, EOLToken, DedentToken}, kinds)
+	})
+}
+
+func TestLexer_indentation_Mark(t *testing.T) {
+	code := "a\n  b\n    c\nd\n"
+	l := NewLexer("mark.test", []byte(code))
+	l.SetIndentation(true)
+	require.True(t, l.Advance())
+	mark := l.Mark()
+	first, err := Tokenize(l, true)
+	require.NoError(t, err)
+	l.Reset(mark)
+	second, err := Tokenize(l, true)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+}
+
+func TestParser_indentation(t *testing.T) {
+	code := "def f(x):\n    // body\n    return (x +\n        1)\n\nprint(f(2))\n"
+	d := NewDialect("python")
+	d.SetIndentation(true)
+	p := NewParser(d.NewLexer("parser.test", []byte(code)))
+	var identities []string
+	var text strings.Builder
+	for {
+		text.WriteString(p.Current().Text())
+		if p.EOF() {
+			break
+		}
+		identities = append(identities, p.Current().Identity())
+		p.Next()
+	}
+	assert.Equal(t, code, text.String())
+	assert.Equal(t, []string{`"def"`, `"f"`, `open-parens ("(")`, `"x"`, `close-parens (")")`, `colon (":")`, "EOL",
+		"INDENT", `"return"`, `open-parens ("(")`, `"x"`, `plus-sign ("+")`, `INTEGER "1"`, `close-parens (")")`, "EOL",
+		"DEDENT", `"print"`, `open-parens ("(")`, `"f"`, `open-parens ("(")`, `INTEGER "2"`, `close-parens (")")`, `close-parens (")")`, "EOL"}, identities)
+}
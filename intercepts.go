@@ -0,0 +1,356 @@
+package parsing
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// CursorIntercept is an intercept that works through a restricted Cursor
+// rather than the whole Lexer, see InterceptRule. It must finish by returning
+// either c.Accept(token) or c.Reject().
+type CursorIntercept func(c *Cursor) bool
+
+// InterceptRule describes when a CursorIntercept is tried.
+type InterceptRule struct {
+	// Prefix is the text at which the intercept is tried, such as "${" or
+	// "<<<". The Cursor begins with the prefix as its text.
+	Prefix string
+	// Token is the base classification of the character at which the
+	// intercept is tried, if Prefix is empty, as for AddIntercept. EOFToken
+	// tries the intercept each time the lexer reaches the end of the source,
+	// with empty text, until it rejects.
+	Token Token
+	// Priority orders the intercepts tried at the same position: highest
+	// first, then in the order they were added.
+	Priority int
+	// Intercept is called with a Cursor positioned after the matched text.
+	Intercept CursorIntercept
+}
+
+// InterceptID identifies a registered InterceptRule, for RemoveIntercept.
+type InterceptID int64
+
+// lastInterceptID is the most recently assigned InterceptID.
+var lastInterceptID int64
+
+// interceptEntry is a registered InterceptRule.
+type interceptEntry struct {
+	InterceptRule
+	id InterceptID
+}
+
+// interceptSet holds the InterceptRules of a Lexer or Dialect, indexed by
+// where they apply and ordered by priority, so that characters without
+// intercepts cost a scan of a few tokens.
+type interceptSet struct {
+	prefixes map[byte][]interceptEntry // by the first byte of Prefix
+	tokens   []tokenIntercepts         // by Token, few enough to scan
+	count    int
+}
+
+// tokenIntercepts are the entries of an interceptSet for a Token.
+type tokenIntercepts struct {
+	token   Token
+	entries []interceptEntry
+}
+
+// byToken returns the entries for a Token, in the order they are tried.
+func (s *interceptSet) byToken(token Token) []interceptEntry {
+	for idx := range s.tokens {
+		if s.tokens[idx].token == token {
+			return s.tokens[idx].entries
+		}
+	}
+	return nil
+}
+
+// addInterceptRule registers a rule with a set, creating it if necessary.
+func addInterceptRule(set **interceptSet, rule InterceptRule) InterceptID {
+	if rule.Intercept == nil {
+		panic("InterceptRule requires an Intercept")
+	}
+	if rule.Prefix == "" && rule.Token.string == nil {
+		panic("InterceptRule requires a Prefix or Token")
+	}
+	if *set == nil {
+		*set = &interceptSet{}
+	}
+	s := *set
+	entry := interceptEntry{rule, InterceptID(atomic.AddInt64(&lastInterceptID, 1))}
+	if rule.Prefix != "" {
+		if s.prefixes == nil {
+			s.prefixes = make(map[byte][]interceptEntry)
+		}
+		s.prefixes[rule.Prefix[0]] = insertIntercept(s.prefixes[rule.Prefix[0]], entry)
+	} else {
+		idx := 0
+		for idx < len(s.tokens) && s.tokens[idx].token != rule.Token {
+			idx++
+		}
+		if idx == len(s.tokens) {
+			if s.tokens == nil {
+				// Room for the handful of tokens that usually have rules.
+				s.tokens = make([]tokenIntercepts, 0, 8)
+			}
+			s.tokens = append(s.tokens, tokenIntercepts{token: rule.Token})
+		}
+		s.tokens[idx].entries = insertIntercept(s.tokens[idx].entries, entry)
+	}
+	s.count++
+	return entry.id
+}
+
+// insertIntercept adds entry to a list in the order the entries are tried.
+func insertIntercept(list []interceptEntry, entry interceptEntry) []interceptEntry {
+	idx := sort.Search(len(list), func(i int) bool { return entry.before(&list[i]) })
+	// Copy, as a running intercept may be iterating the list.
+	inserted := make([]interceptEntry, 0, len(list)+1)
+	inserted = append(append(append(inserted, list[:idx]...), entry), list[idx:]...)
+	return inserted
+}
+
+// removeInterceptRule unregisters a rule from a set, discarding the set once
+// it is empty. Returns false if the rule isn't in the set.
+func removeInterceptRule(set **interceptSet, id InterceptID) bool {
+	s := *set
+	if s == nil {
+		return false
+	}
+	removed := false
+	for char, list := range s.prefixes {
+		if list, removed = removeIntercept(list, id); removed {
+			s.prefixes[char] = list
+			if len(list) == 0 {
+				delete(s.prefixes, char)
+			}
+			break
+		}
+	}
+	for idx := 0; idx < len(s.tokens) && !removed; idx++ {
+		var list []interceptEntry
+		if list, removed = removeIntercept(s.tokens[idx].entries, id); removed {
+			s.tokens[idx].entries = list
+			if len(list) == 0 {
+				s.tokens = append(s.tokens[:idx:idx], s.tokens[idx+1:]...)
+			}
+		}
+	}
+	if removed {
+		if s.count--; s.count == 0 {
+			*set = nil
+		}
+	}
+	return removed
+}
+
+// removeIntercept removes the entry with the given id from a list.
+func removeIntercept(list []interceptEntry, id InterceptID) ([]interceptEntry, bool) {
+	for idx, entry := range list {
+		if entry.id == id {
+			// Copy, as a running intercept may be iterating the list.
+			return append(list[:idx:idx], list[idx+1:]...), true
+		}
+	}
+	return list, false
+}
+
+// before returns true if entry should be tried before other.
+func (entry *interceptEntry) before(other *interceptEntry) bool {
+	if entry.Priority != other.Priority {
+		return entry.Priority > other.Priority
+	}
+	return entry.id < other.id
+}
+
+// AddInterceptRule registers a CursorIntercept with the lexer. Like those
+// added with AddIntercept, it only applies in the base mode and is tried
+// before those of the Dialect. Returns an ID for RemoveIntercept.
+func (l *Lexer) AddInterceptRule(rule InterceptRule) InterceptID {
+	return addInterceptRule(&l.rules, rule)
+}
+
+// RemoveIntercept unregisters an InterceptRule added to the lexer, returning
+// false if it isn't registered.
+func (l *Lexer) RemoveIntercept(id InterceptID) bool {
+	return removeInterceptRule(&l.rules, id)
+}
+
+// AddInterceptRule registers a CursorIntercept with the dialect, see
+// Lexer.AddInterceptRule. Returns an ID for RemoveIntercept.
+func (d *Dialect) AddInterceptRule(rule InterceptRule) InterceptID {
+	return addInterceptRule(&d.rules, rule)
+}
+
+// RemoveIntercept unregisters an InterceptRule added to the dialect,
+// returning false if it isn't registered.
+func (d *Dialect) RemoveIntercept(id InterceptID) bool {
+	return removeInterceptRule(&d.rules, id)
+}
+
+// Cursor is the view of the lexer given to a CursorIntercept: the text of
+// the token so far, which the intercept may extend and then Accept as a
+// token, or Reject so that the lexer carries on as though the intercept
+// hadn't been called. A Cursor is only valid during the call.
+type Cursor struct {
+	lexer    *Lexer
+	end      int
+	token    Token
+	modes    []*Dialect // modes to push, or nil to pop, on Accept
+	accepted bool
+}
+
+// Token returns the base classification of the token's first character, or
+// EOFToken at the end of the source.
+func (c *Cursor) Token() Token {
+	return c.lexer.Token
+}
+
+// Text returns the text of the token so far. The slice is only valid until
+// the intercept returns.
+func (c *Cursor) Text() []byte {
+	text, _ := c.lexer.Slice(c.lexer.Start, c.end)
+	return text
+}
+
+// Peek returns the byte n bytes beyond the text of the token, so that Peek(0)
+// is the next byte. ok is false beyond the end of the source.
+func (c *Cursor) Peek(n int) (char byte, ok bool) {
+	return c.lexer.byteAt(c.end + n)
+}
+
+// HasPrefix returns true if the source following the text of the token
+// begins with prefix.
+func (c *Cursor) HasPrefix(prefix string) bool {
+	return c.lexer.hasPrefix(c.end, prefix)
+}
+
+// Advance adds the next n bytes to the text of the token. Returns false,
+// having advanced as far as possible, if the source ends first.
+func (c *Cursor) Advance(n int) bool {
+	for ; n > 0; n-- {
+		if _, ok := c.Peek(0); !ok {
+			return false
+		}
+		c.end++
+	}
+	return true
+}
+
+// PushMode pushes a mode, see Lexer.PushMode, if the token is accepted.
+func (c *Cursor) PushMode(mode *Dialect) {
+	if mode == nil {
+		panic("PushMode requires a Dialect")
+	}
+	c.modes = append(c.modes, mode)
+}
+
+// PopMode pops the current mode, see Lexer.PopMode, if the token is accepted.
+func (c *Cursor) PopMode() {
+	c.modes = append(c.modes, nil)
+}
+
+// Accept completes the token as the given Token. Returns true, for the
+// intercept to return.
+func (c *Cursor) Accept(token Token) bool {
+	c.token, c.accepted = token, true
+	return true
+}
+
+// Reject declines the token, discarding any changes. Returns false, for the
+// intercept to return.
+func (c *Cursor) Reject() bool {
+	c.accepted = false
+	return false
+}
+
+// intercept tries the intercepts of the lexer, in the base mode, and then
+// those of the current mode on the current token.
+func (l *Lexer) intercept() bool {
+	if l.inBaseMode() && (l.rules != nil || l.intercepts != nil) && l.runIntercepts(l.rules, l.intercepts) {
+		return true
+	}
+	dialect := l.Mode()
+	return (dialect.rules != nil || dialect.intercepts != nil) && l.runIntercepts(dialect.rules, dialect.intercepts)
+}
+
+// interceptEOF tries the cursor intercepts registered for EOFToken at the end
+// of the source, returning true if one of them produces a token.
+func (l *Lexer) interceptEOF() bool {
+	dialect := l.Mode()
+	if l.inBaseMode() && l.rules != nil && l.runIntercepts(l.rules, nil) {
+		return true
+	}
+	return dialect.rules != nil && l.runIntercepts(dialect.rules, nil)
+}
+
+// runIntercepts tries a set of cursor intercepts and then a table of legacy
+// intercepts, returning true if one of them classifies the token.
+func (l *Lexer) runIntercepts(rules *interceptSet, legacy InterceptTable) bool {
+	if rules != nil {
+		var prefixed []interceptEntry
+		if len(rules.prefixes) > 0 && l.Token != EOFToken {
+			if char, ok := l.byteAt(l.Start); ok {
+				prefixed = rules.prefixes[char]
+			}
+		}
+		classified := rules.byToken(l.Token)
+		// Merge by priority while there are prefixed rules, then try the rest.
+		for len(prefixed) > 0 {
+			entry := &prefixed[0]
+			if len(classified) > 0 && classified[0].before(entry) {
+				entry, classified = &classified[0], classified[1:]
+			} else {
+				prefixed = prefixed[1:]
+			}
+			if l.runCursor(entry) {
+				return true
+			}
+		}
+		for idx := range classified {
+			if l.runCursor(&classified[idx]) {
+				return true
+			}
+		}
+	}
+	if legacy == nil {
+		return false
+	}
+	for _, intercept := range legacy[l.Token] {
+		// Changes made by an intercept that declines are discarded.
+		start, end, token, modes := l.Start, l.End, l.Token, l.modes
+		if intercept(l) {
+			return true
+		}
+		l.Start, l.End, l.Token, l.modes = start, end, token, modes
+	}
+	return false
+}
+
+// runCursor calls a cursor intercept if its prefix matches, and applies the
+// token if it is accepted.
+func (l *Lexer) runCursor(entry *interceptEntry) bool {
+	end := l.End
+	if entry.Prefix != "" {
+		if !l.hasPrefix(l.Start, entry.Prefix) {
+			return false
+		}
+		end = l.Start + len(entry.Prefix)
+	}
+	c := &l.cursor
+	c.lexer, c.end, c.modes, c.accepted = l, end, c.modes[:0], false
+	if !entry.Intercept(c) {
+		return false
+	}
+	if !c.accepted {
+		panic("CursorIntercept returned true without calling Accept")
+	}
+	l.End, l.Token = c.end, c.token
+	for _, mode := range c.modes {
+		if mode == nil {
+			l.PopMode()
+		} else {
+			l.PushMode(mode)
+		}
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package parsing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	heredocToken = NewTerminal("intercept-heredoc")
+	shiftToken   = NewTerminal("intercept-shift")
+	wordToken    = NewTerminal("intercept-word")
+	closeToken   = NewTerminal("intercept-close")
+)
+
+// heredoc accepts "<<<" followed by text up to and including the next "<<<".
+func heredoc(c *Cursor) bool {
+	for !c.HasPrefix("<<<") {
+		if !c.Advance(1) {
+			return c.Reject()
+		}
+	}
+	c.Advance(3)
+	return c.Accept(heredocToken)
+}
+
+func TestLexer_AddInterceptRule(t *testing.T) {
+	t.Run("prefix", func(t *testing.T) {
+		l := NewLexer("prefix.test", []byte("a <<<b c<<< < <<<d"))
+		l.AddInterceptRule(InterceptRule{Prefix: "<<<", Intercept: heredoc})
+		tokens, values := lexTokens(l)
+		assert.Equal(t, []Token{IdentifierToken, heredocToken, SymbolToken, SymbolToken, SymbolToken, SymbolToken, IdentifierToken}, tokens)
+		assert.Equal(t, []string{"a", "<<<b c<<<", "<", "<", "<", "<", "d"}, values)
+	})
+
+	t.Run("token", func(t *testing.T) {
+		l := NewLexer("token.test", []byte("a-b c"))
+		l.AddInterceptRule(InterceptRule{Token: AlphaToken, Intercept: func(c *Cursor) bool {
+			assert.Equal(t, AlphaToken, c.Token())
+			for char, ok := c.Peek(0); ok && (TokenMap[char] == AlphaToken || char == '-'); char, ok = c.Peek(0) {
+				c.Advance(1)
+			}
+			return c.Accept(wordToken)
+		}})
+		tokens, values := lexTokens(l)
+		assert.Equal(t, []Token{wordToken, wordToken}, tokens)
+		assert.Equal(t, []string{"a-b", "c"}, values)
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		l := NewLexer("priority.test", []byte("<<x <<<y<<<"))
+		var calls []string
+		l.AddInterceptRule(InterceptRule{Token: SymbolToken, Intercept: func(c *Cursor) bool {
+			calls = append(calls, "token")
+			if !c.HasPrefix("<") {
+				return c.Reject()
+			}
+			c.Advance(1)
+			return c.Accept(shiftToken)
+		}})
+		l.AddInterceptRule(InterceptRule{Prefix: "<<<", Priority: 1, Intercept: func(c *Cursor) bool {
+			calls = append(calls, "heredoc")
+			return heredoc(c)
+		}})
+		tokens, values := lexTokens(l)
+		assert.Equal(t, []Token{shiftToken, IdentifierToken, heredocToken}, tokens)
+		assert.Equal(t, []string{"<<", "x", "<<<y<<<"}, values)
+		assert.Equal(t, []string{"token", "heredoc"}, calls)
+	})
+
+	t.Run("order", func(t *testing.T) {
+		l := NewLexer("order.test", []byte("+"))
+		var calls []int
+		for idx := 0; idx < 3; idx++ {
+			idx := idx
+			l.AddInterceptRule(InterceptRule{Token: Plus, Priority: idx % 2, Intercept: func(c *Cursor) bool {
+				calls = append(calls, idx)
+				return c.Reject()
+			}})
+		}
+		tokens, _ := lexTokens(l)
+		assert.Equal(t, []Token{Plus}, tokens)
+		assert.Equal(t, []int{1, 0, 2}, calls)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		l := NewLexer("reject.test", []byte("<<<unterminated"))
+		l.AddInterceptRule(InterceptRule{Prefix: "<<<", Intercept: heredoc})
+		l.AddInterceptRule(InterceptRule{Prefix: "<", Intercept: func(c *Cursor) bool {
+			assert.Equal(t, "<", string(c.Text()))
+			char, ok := c.Peek(0)
+			assert.True(t, ok)
+			assert.Equal(t, byte('<'), char)
+			return c.Reject()
+		}})
+		require.True(t, l.Advance())
+		assert.Equal(t, SymbolToken, l.Token)
+		assert.Equal(t, 0, l.Start)
+		assert.Equal(t, 1, l.End)
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		l := NewLexer("legacy.test", []byte("+-"))
+		l.AddIntercept(Plus, func(l *Lexer) bool {
+			l.End = 100
+			return false
+		})
+		l.AddIntercept(Minus, func(l *Lexer) bool {
+			l.Token = shiftToken
+			return true
+		})
+		l.AddInterceptRule(InterceptRule{Token: Minus, Intercept: func(c *Cursor) bool {
+			return c.Accept(wordToken)
+		}})
+		tokens, _ := lexTokens(l)
+		assert.Equal(t, []Token{Plus, wordToken}, tokens)
+	})
+
+	t.Run("unaccepted", func(t *testing.T) {
+		l := NewLexer("unaccepted.test", []byte("+"))
+		l.AddInterceptRule(InterceptRule{Token: Plus, Intercept: func(*Cursor) bool { return true }})
+		assert.PanicsWithValue(t, "CursorIntercept returned true without calling Accept", func() { l.Advance() })
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		l := NewLexer("invalid.test", nil)
+		assert.PanicsWithValue(t, "InterceptRule requires an Intercept", func() {
+			l.AddInterceptRule(InterceptRule{Prefix: "x"})
+		})
+		assert.PanicsWithValue(t, "InterceptRule requires a Prefix or Token", func() {
+			l.AddInterceptRule(InterceptRule{Intercept: heredoc})
+		})
+	})
+}
+
+func TestLexer_RemoveIntercept(t *testing.T) {
+	l := NewLexer("remove.test", []byte("<<<a<<< <<<b<<<"))
+	first := l.AddInterceptRule(InterceptRule{Prefix: "<<<", Intercept: heredoc})
+	second := l.AddInterceptRule(InterceptRule{Token: Plus, Intercept: heredoc})
+	assert.NotEqual(t, first, second)
+
+	require.True(t, l.Advance())
+	assert.Equal(t, heredocToken, l.Token)
+	assert.True(t, l.RemoveIntercept(first))
+	assert.False(t, l.RemoveIntercept(first))
+	require.True(t, l.Advance())
+	require.True(t, l.Advance())
+	assert.Equal(t, SymbolToken, l.Token)
+
+	assert.True(t, l.RemoveIntercept(second))
+	assert.Nil(t, l.rules)
+	assert.False(t, l.RemoveIntercept(second))
+}
+
+func TestLexer_interceptEOF(t *testing.T) {
+	base, expr := NewDialect("eof-base"), NewDialect("eof-expr")
+	open := InterceptRule{Prefix: "${", Intercept: func(c *Cursor) bool {
+		c.PushMode(expr)
+		return c.Accept(modeOpen)
+	}}
+	base.AddInterceptRule(open)
+	expr.AddInterceptRule(open)
+	expr.AddInterceptRule(InterceptRule{Token: CloseBrace, Intercept: func(c *Cursor) bool {
+		c.PopMode()
+		return c.Accept(modeClose)
+	}})
+	// Close any modes left open at the end of the source.
+	expr.AddInterceptRule(InterceptRule{Token: EOFToken, Intercept: func(c *Cursor) bool {
+		assert.Empty(t, c.Text())
+		c.PopMode()
+		return c.Accept(closeToken)
+	}})
+
+	l := base.NewLexer("eof.test", []byte("${a ${b} ${c"))
+	tokens, values := lexTokens(l)
+	assert.Equal(t, []Token{modeOpen, IdentifierToken, modeOpen, IdentifierToken, modeClose, modeOpen, IdentifierToken, closeToken, closeToken}, tokens)
+	assert.Equal(t, []string{"${", "a", "${", "b", "}", "${", "c", "", ""}, values)
+	assert.Equal(t, EOFToken, l.Token)
+	assert.Equal(t, 0, l.ModeDepth())
+}
+
+func TestCursor(t *testing.T) {
+	l := NewLexer("cursor.test", []byte("#abc"))
+	l.AddInterceptRule(InterceptRule{Prefix: "#a", Intercept: func(c *Cursor) bool {
+		assert.Equal(t, "#a", string(c.Text()))
+		assert.True(t, c.HasPrefix("bc"))
+		assert.False(t, c.HasPrefix("bcd"))
+		char, ok := c.Peek(1)
+		assert.True(t, ok)
+		assert.Equal(t, byte('c'), char)
+		_, ok = c.Peek(2)
+		assert.False(t, ok)
+
+		c.PushMode(NewDialect("cursor"))
+		assert.False(t, c.Advance(3))
+		assert.Equal(t, "#abc", string(c.Text()))
+		return c.Reject()
+	}})
+	require.True(t, l.Advance())
+	assert.Equal(t, SymbolToken, l.Token)
+	assert.Equal(t, "#", l.String())
+	assert.Equal(t, 0, l.ModeDepth())
+}
+
+// interceptBenchmarkTokens are intercepted, and declined, by the many
+// intercepts benchmarks.
+var interceptBenchmarkTokens = []Token{SymbolToken, Plus, Minus, Asterisk, Slash, OpenParen, CloseParen, Colon, Equals, Semicolon}
+
+func BenchmarkLexer_manyIntercepts(b *testing.B) {
+	code := []byte(strings.Repeat("alpha := beta + 12 * (gamma - delta) / 3; // note\n", 200))
+	b.SetBytes(int64(len(code)))
+	for i := 0; i < b.N; i++ {
+		l := NewLexer("bench", code)
+		for _, token := range interceptBenchmarkTokens {
+			l.AddIntercept(token, func(l *Lexer) bool { return false })
+		}
+		for l.Advance() {
+		}
+	}
+}
+
+func BenchmarkLexer_manyCursorIntercepts(b *testing.B) {
+	code := []byte(strings.Repeat("alpha := beta + 12 * (gamma - delta) / 3; // note\n", 200))
+	b.SetBytes(int64(len(code)))
+	for i := 0; i < b.N; i++ {
+		l := NewLexer("bench", code)
+		for _, token := range interceptBenchmarkTokens {
+			l.AddInterceptRule(InterceptRule{Token: token, Intercept: func(c *Cursor) bool { return c.Reject() }})
+		}
+		for l.Advance() {
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package parsing
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Symbol values are the most frequent allocation of a lexer. Identifiers and
+// keywords recur throughout a source, so short ones are interned, per lexer
+// or, for lexers sharing a FileSet, per set. Other values are copied from the
+// source, unless the lexer is in zero-copy mode, in which case they refer to
+// the source itself. Streaming lexers only intern when asked to, see
+// SetInterning, as their table grows for as long as they run.
+
+// internLimit is the length beyond which words are not interned.
+const internLimit = 32
+
+// symbolSlabSize is the number of Symbols allocated at a time by a symbolSlab.
+const symbolSlabSize = 128
+
+// internTable holds a single copy of each short word lexed.
+type internTable struct {
+	mutex   sync.Mutex
+	shared  bool // whether the table belongs to a FileSet
+	strings map[string]string
+}
+
+// intern returns the interned copy of text.
+func (t *internTable) intern(text []byte) string {
+	if t.shared {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+	}
+	// The conversion in the index expression doesn't allocate.
+	if value, ok := t.strings[string(text)]; ok {
+		return value
+	}
+	value := string(text)
+	t.strings[value] = value
+	return value
+}
+
+// symbolSlab hands out Symbols, and slices of them, from larger blocks, so
+// that symbols cost one allocation per block rather than one each. Blocks are
+// never reused, so symbols remain valid for as long as they are referenced.
+type symbolSlab struct {
+	block []Symbol
+}
+
+// alloc returns a zeroed slice of n symbols with no spare capacity, so that
+// appending to it doesn't overwrite its neighbours.
+func (s *symbolSlab) alloc(n int) []Symbol {
+	if n > symbolSlabSize/4 {
+		return make([]Symbol, n)
+	}
+	if len(s.block) < n {
+		s.block = make([]Symbol, symbolSlabSize)
+	}
+	symbols := s.block[:n:n]
+	s.block = s.block[n:]
+	return symbols
+}
+
+// SetZeroCopy determines whether the values of Symbols refer directly to the
+// source rather than to copies of it, avoiding an allocation for each long
+// value such as a string or comment. The source must then not be modified
+// while the symbols are in use. Panics for streaming lexers, which reuse
+// their buffer.
+func (l *Lexer) SetZeroCopy(enabled bool) {
+	if enabled && l.reader != nil {
+		panic("SetZeroCopy requires a lexer with an in-memory source")
+	}
+	l.zeroCopy = enabled
+}
+
+// SetInterning determines whether the lexer interns the values of short
+// identifiers and keywords. Interning is enabled by default, except for
+// streaming lexers, whose table would otherwise grow without bound.
+func (l *Lexer) SetInterning(enabled bool) {
+	l.interning = enabled
+}
+
+// value returns the value of the current token for a Symbol: interned if it
+// is a short word, otherwise copied or, in zero-copy mode, referring to the
+// source.
+func (l *Lexer) value() string {
+	text := l.Value()
+	switch {
+	case len(text) == 0:
+		return ""
+	case l.interning && len(text) <= internLimit && l.isWord(text):
+		if l.interned == nil {
+			l.interned = &internTable{strings: make(map[string]string)}
+		}
+		return l.interned.intern(text)
+	case l.zeroCopy && l.reader == nil:
+		return *(*string)(unsafe.Pointer(&text))
+	}
+	return string(text)
+}
+
+// isWord returns true if the current token, whose text is text, is an
+// identifier or a reserved keyword.
+func (l *Lexer) isWord(text []byte) bool {
+	if l.Token == IdentifierToken {
+		return true
+	}
+	if !l.Token.IsTerminal() {
+		return false
+	}
+	keyword, ok := l.reservedKeyword(l.Mode(), text)
+	return ok && keyword == l.Token
+}
@@ -0,0 +1,123 @@
+package parsing
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stringData returns the address of the bytes of a string.
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
+func TestLexer_value(t *testing.T) {
+	long := strings.Repeat("x", internLimit+1)
+	code := []byte("alpha alpha \"" + long + "\" \"" + long + "\"")
+
+	t.Run("interned", func(t *testing.T) {
+		tokens, err := Tokenize(NewLexer("intern.test", code), false)
+		require.NoError(t, err)
+		require.Len(t, tokens, 4)
+		assert.Equal(t, "alpha", tokens[1].Value)
+		assert.Equal(t, stringData(tokens[0].Value), stringData(tokens[1].Value))
+		assert.Equal(t, tokens[2].Value, tokens[3].Value)
+		assert.NotEqual(t, stringData(tokens[2].Value), stringData(tokens[3].Value))
+	})
+
+	t.Run("words only", func(t *testing.T) {
+		keywordIf := NewTerminal("intern-if")
+		d := NewDialect("intern-words")
+		d.AddKeyword("if", keywordIf)
+		tokens, err := Tokenize(d.NewLexer("words.test", []byte(`if if 12 12 "s" "s"`)), false)
+		require.NoError(t, err)
+		require.Len(t, tokens, 6)
+		assert.Equal(t, keywordIf, tokens[0].Token)
+		assert.Equal(t, stringData(tokens[0].Value), stringData(tokens[1].Value))
+		for idx := 2; idx < len(tokens); idx += 2 {
+			assert.Equal(t, tokens[idx].Value, tokens[idx+1].Value)
+			assert.NotEqual(t, stringData(tokens[idx].Value), stringData(tokens[idx+1].Value), tokens[idx].Value)
+		}
+	})
+
+	t.Run("file set", func(t *testing.T) {
+		set := NewFileSet()
+		var values []string
+		for _, name := range []string{"first.test", "second.test"} {
+			l := NewLexer(name, []byte("beta"))
+			l.SetFileSet(set)
+			require.True(t, l.Advance())
+			values = append(values, l.symbol(nil).Value)
+		}
+		assert.Equal(t, stringData(values[0]), stringData(values[1]))
+	})
+
+	t.Run("zero copy", func(t *testing.T) {
+		source := append([]byte(nil), code...)
+		l := NewLexer("zerocopy.test", source)
+		l.SetZeroCopy(true)
+		tokens, err := Tokenize(l, false)
+		require.NoError(t, err)
+		require.Len(t, tokens, 4)
+		assert.Equal(t, uintptr(unsafe.Pointer(&source[tokens[2].StartOffset])), stringData(tokens[2].Value))
+		// Short words are still interned.
+		assert.Equal(t, stringData(tokens[0].Value), stringData(tokens[1].Value))
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		l := NewReaderLexer("stream.test", strings.NewReader("x"))
+		assert.PanicsWithValue(t, "SetZeroCopy requires a lexer with an in-memory source", func() { l.SetZeroCopy(true) })
+		assert.NotPanics(t, func() { l.SetZeroCopy(false) })
+
+		tokens, err := Tokenize(NewReaderLexer("stream.test", strings.NewReader("gamma gamma")), false)
+		require.NoError(t, err)
+		require.Len(t, tokens, 2)
+		assert.NotEqual(t, stringData(tokens[0].Value), stringData(tokens[1].Value))
+
+		l = NewReaderLexer("stream.test", strings.NewReader("gamma gamma"))
+		l.SetInterning(true)
+		tokens, err = Tokenize(l, false)
+		require.NoError(t, err)
+		require.Len(t, tokens, 2)
+		assert.Equal(t, stringData(tokens[0].Value), stringData(tokens[1].Value))
+	})
+}
+
+func Test_symbolSlab(t *testing.T) {
+	var slab symbolSlab
+	first, second := slab.alloc(2), slab.alloc(1)
+	assert.Len(t, first, 2)
+	assert.Equal(t, 2, cap(first))
+	// Successive allocations share a block.
+	assert.Equal(t, uintptr(unsafe.Pointer(&first[1]))+unsafe.Sizeof(Symbol{}), uintptr(unsafe.Pointer(&second[0])))
+	_ = append(first, Symbol{Value: "appended"})
+	assert.Equal(t, "", second[0].Value)
+
+	large := slab.alloc(symbolSlabSize)
+	assert.Len(t, large, symbolSlabSize)
+	assert.Len(t, slab.block, symbolSlabSize-3)
+}
+
+func TestLexer_reservedKeyword(t *testing.T) {
+	d := NewDialect("keyword-allocs")
+	d.AddKeyword("func", NewTerminal("allocs-func"))
+	d.AddKeywordFlags("select", NewTerminal("allocs-select"), CaseInsensitive)
+	l := d.NewLexer("allocs.test", nil)
+	l.AddKeywordFlags("where", NewTerminal("allocs-where"), CaseInsensitive)
+	l.AddKeywordFlags("école", NewTerminal("allocs-ecole"), CaseInsensitive)
+	for _, word := range []string{"func", "SELECT", "Where", "other"} {
+		word := []byte(word)
+		allocs := testing.AllocsPerRun(100, func() { l.reservedKeyword(d, word) })
+		assert.Zero(t, allocs, string(word))
+	}
+	token, ok := l.reservedKeyword(d, []byte("SeLeCt"))
+	assert.True(t, ok)
+	assert.Equal(t, "allocs-select", token.String())
+	token, ok = l.reservedKeyword(d, []byte("ÉCOLE"))
+	assert.True(t, ok)
+	assert.Equal(t, "allocs-ecole", token.String())
+}
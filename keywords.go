@@ -0,0 +1,181 @@
+package parsing
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// KeywordFlags modify how a keyword is recognized, see AddKeywordFlags.
+type KeywordFlags uint
+
+const (
+	// CaseInsensitive keywords match regardless of case, e.g. SELECT, Select
+	// and select, with the case folding of strings.EqualFold and of patterns
+	// with the (?i) flag. The symbol's Value keeps the spelling used in the
+	// source.
+	CaseInsensitive KeywordFlags = 1 << iota
+	// Contextual keywords are not reserved: the lexer returns them as an
+	// IdentifierToken and the parser decides whether they are a keyword in
+	// that position, see Parser.AcceptKeyword.
+	Contextual
+)
+
+// flaggedKeyword is a keyword registered with flags. CaseInsensitive keywords
+// are indexed by their spelling after foldCase.
+type flaggedKeyword struct {
+	token Token
+	flags KeywordFlags
+}
+
+// checkKeywordToken panics unless token is a Terminal, as keywords must be.
+func checkKeywordToken(token Token) {
+	if !token.IsTerminal() {
+		panic(fmt.Sprintf("keywords must be represented by Terminals: %q is a Token", token.String()))
+	}
+}
+
+// addFlaggedKeyword registers a keyword with flags in keywords, creating it
+// if necessary.
+func addFlaggedKeyword(keywords map[string]flaggedKeyword, keyword string, token Token, flags KeywordFlags) map[string]flaggedKeyword {
+	checkKeywordToken(token)
+	if keywords == nil {
+		keywords = make(map[string]flaggedKeyword)
+	}
+	if flags&CaseInsensitive != 0 {
+		keyword = foldCase(keyword)
+	}
+	keywords[keyword] = flaggedKeyword{token, flags}
+	return keywords
+}
+
+// lookupFlaggedKeyword finds the keyword registered for word, if any.
+func lookupFlaggedKeyword(keywords map[string]flaggedKeyword, word string) (flaggedKeyword, bool) {
+	if keywords == nil {
+		return flaggedKeyword{}, false
+	}
+	if keyword, ok := keywords[word]; ok {
+		return keyword, true
+	}
+	keyword, ok := keywords[foldCase(word)]
+	return keyword, ok && keyword.flags&CaseInsensitive != 0
+}
+
+// foldCase returns a spelling of word shared by every word that equals it
+// under Unicode simple case folding, as used by strings.EqualFold: each rune
+// is replaced by the lowest rune it folds to, so that ASCII letters become
+// upper case.
+func foldCase(word string) string {
+	return strings.Map(foldRune, word)
+}
+
+// foldRune returns the lowest rune in the case folding orbit of r.
+func foldRune(r rune) rune {
+	folded := r
+	for fold := unicode.SimpleFold(r); fold != r; fold = unicode.SimpleFold(fold) {
+		if fold < folded {
+			folded = fold
+		}
+	}
+	return folded
+}
+
+// lookupFlaggedKeywordBytes is lookupFlaggedKeyword for the text of a token,
+// without allocating for ASCII words of up to internLimit bytes.
+func lookupFlaggedKeywordBytes(keywords map[string]flaggedKeyword, word []byte) (flaggedKeyword, bool) {
+	if keywords == nil {
+		return flaggedKeyword{}, false
+	}
+	if keyword, ok := keywords[string(word)]; ok {
+		return keyword, true
+	}
+	var buffer [internLimit]byte
+	folded := buffer[:0]
+	if len(word) > len(buffer) {
+		folded = make([]byte, 0, len(word))
+	}
+	for _, char := range word {
+		if char >= utf8.RuneSelf {
+			// Leave case folding of other scripts to foldCase.
+			keyword, ok := keywords[foldCase(string(word))]
+			return keyword, ok && keyword.flags&CaseInsensitive != 0
+		}
+		if 'a' <= char && char <= 'z' {
+			char -= 'a' - 'A'
+		}
+		folded = append(folded, char)
+	}
+	keyword, ok := keywords[string(folded)]
+	return keyword, ok && keyword.flags&CaseInsensitive != 0
+}
+
+// AddKeywordFlags registers a keyword Terminal with the dialect, like
+// AddKeyword, with flags that modify how it is recognized.
+func (d *Dialect) AddKeywordFlags(keyword string, token Token, flags KeywordFlags) {
+	if flags == 0 {
+		d.AddKeyword(keyword, token)
+		return
+	}
+	d.flaggedKeywords = addFlaggedKeyword(d.flaggedKeywords, keyword, token, flags)
+	d.invalidate()
+}
+
+// AddKeywordFlags registers a keyword Terminal with the lexer, like
+// AddKeyword, with flags that modify how it is recognized.
+func (l *Lexer) AddKeywordFlags(keyword string, token Token, flags KeywordFlags) {
+	if flags == 0 {
+		l.AddKeyword(keyword, token)
+		return
+	}
+	l.flaggedKeywords = addFlaggedKeyword(l.flaggedKeywords, keyword, token, flags)
+}
+
+// reservedKeyword returns the reserved keyword, if any, that word spells in
+// the given dialect, taking the lexer's own keywords into account in base
+// mode.
+// The lookup doesn't allocate.
+func (l *Lexer) reservedKeyword(dialect *Dialect, word []byte) (Token, bool) {
+	if l.inBaseMode() {
+		if keyword, ok := l.keywords[string(word)]; ok {
+			return keyword, true
+		}
+		if keyword, ok := lookupFlaggedKeywordBytes(l.flaggedKeywords, word); ok && keyword.flags&Contextual == 0 {
+			return keyword.token, true
+		}
+	}
+	if token, ok := dialect.keywords[string(word)]; ok {
+		return token, true
+	}
+	if keyword, ok := lookupFlaggedKeywordBytes(dialect.flaggedKeywords, word); ok && keyword.flags&Contextual == 0 {
+		return keyword.token, true
+	}
+	return InvalidToken, false
+}
+
+// contextualKeyword returns true if word is a contextual keyword for token
+// in the given dialect.
+func (l *Lexer) contextualKeyword(dialect *Dialect, word string, token Token) bool {
+	if dialect == l.Dialect() {
+		if keyword, ok := lookupFlaggedKeyword(l.flaggedKeywords, word); ok && keyword.token == token {
+			return keyword.flags&Contextual != 0
+		}
+	}
+	keyword, ok := lookupFlaggedKeyword(dialect.flaggedKeywords, word)
+	return ok && keyword.token == token && keyword.flags&Contextual != 0
+}
+
+// AcceptKeyword reinterprets the current symbol as the keyword token if it is
+// an IdentifierToken spelling a Contextual keyword for token. The symbol's
+// Value retains the original spelling. Returns true if the current symbol is,
+// or now is, token.
+func (p *Parser) AcceptKeyword(token Token) bool {
+	if p.current.Token == token {
+		return true
+	}
+	if p.current.Token != IdentifierToken || !p.Lexer.contextualKeyword(p.Mode(), p.current.Value, token) {
+		return false
+	}
+	p.current.Token = token
+	return true
+}
@@ -0,0 +1,133 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	kwSelect = NewTerminal("kw-select")
+	kwAsync  = NewTerminal("kw-async")
+	kwFunc   = NewTerminal("kw-func")
+	kwPrint  = NewTerminal("kw-print")
+	kwKelvin = NewTerminal("kw-kelvin")
+)
+
+func TestDialect_AddKeywordFlags(t *testing.T) {
+	d := NewDialect("sql")
+	d.AddKeywordFlags("Select", kwSelect, CaseInsensitive)
+	d.AddKeywordFlags("async", kwAsync, Contextual)
+	d.AddKeywordFlags("func", kwFunc, 0)
+	assert.Equal(t, map[string]Token{"func": kwFunc}, d.keywords)
+
+	tests := []struct {
+		word  string
+		token Token
+		ok    bool
+	}{
+		{"select", kwSelect, true},
+		{"SELECT", kwSelect, true},
+		{"sElEcT", kwSelect, true},
+		{"selects", InvalidToken, false},
+		{"func", kwFunc, true},
+		{"FUNC", InvalidToken, false},
+		{"async", InvalidToken, false},
+	}
+	for _, tt := range tests {
+		token, ok := d.Keyword(tt.word)
+		assert.Equal(t, tt.ok, ok, tt.word)
+		assert.Equal(t, tt.token, token, tt.word)
+	}
+
+	tokens, values := lexTokens(d.NewLexer("sql.test", []byte("SELECT select Selected async Func")))
+	assert.Equal(t, []Token{kwSelect, kwSelect, IdentifierToken, IdentifierToken, IdentifierToken}, tokens)
+	assert.Equal(t, []string{"SELECT", "select", "Selected", "async", "Func"}, values)
+
+	assert.Panics(t, func() { d.AddKeywordFlags("x", IdentifierToken, CaseInsensitive) })
+}
+
+func TestLexer_AddKeywordFlags(t *testing.T) {
+	l := NewLexer("basic.test", []byte("PRINT print Print async"))
+	l.AddKeywordFlags("print", kwPrint, CaseInsensitive)
+	l.AddKeywordFlags("async", kwAsync, Contextual|CaseInsensitive)
+	tokens, _ := lexTokens(l)
+	assert.Equal(t, []Token{kwPrint, kwPrint, kwPrint, IdentifierToken}, tokens)
+	assert.Nil(t, DefaultDialect.flaggedKeywords)
+
+	// The lexer's keywords don't apply in other modes.
+	l = NewLexer("basic.test", []byte("PRINT"))
+	l.AddKeywordFlags("print", kwPrint, CaseInsensitive)
+	l.PushMode(NewDialect("other"))
+	tokens, _ = lexTokens(l)
+	assert.Equal(t, []Token{IdentifierToken}, tokens)
+}
+
+func TestLexer_AddKeywordFlags_patterns(t *testing.T) {
+	d := NewDialect("patterns")
+	d.AddKeywordFlags("select", kwSelect, CaseInsensitive)
+	d.AddKeywordFlags("async", kwAsync, Contextual)
+	require.NoError(t, d.AddPattern(LowerToken, "[a-zA-Z]+"))
+	tokens, _ := lexTokens(d.NewLexer("patterns.test", []byte("SeLeCt async selects")))
+	assert.Equal(t, []Token{kwSelect, LowerToken, LowerToken}, tokens)
+}
+
+func TestDialect_AddKeywordFlags_folding(t *testing.T) {
+	// U+212A KELVIN SIGN folds to K, and U+017F LATIN SMALL LETTER LONG S to S.
+	const code = "\u212Aelvin KELVIN \u017Felect SELECT"
+	for _, patterns := range []bool{false, true} {
+		d := NewDialect("folding")
+		d.SetUnicode(true)
+		d.AddKeywordFlags("kelvin", kwKelvin, CaseInsensitive)
+		d.AddKeywordFlags("\u017Felect", kwSelect, CaseInsensitive)
+		if patterns {
+			require.NoError(t, d.AddPattern(LowerToken, "[a-z]+"))
+		}
+		tokens, _ := lexTokens(d.NewLexer("folding.test", []byte(code)))
+		assert.Equal(t, []Token{kwKelvin, kwKelvin, kwSelect, kwSelect}, tokens, "patterns=%v", patterns)
+
+		for _, word := range []string{"\u212Aelvin", "KELVIN", "\u017Felect", "SELECT"} {
+			_, ok := d.Keyword(word)
+			assert.True(t, ok, word)
+		}
+	}
+}
+
+func TestParser_AcceptKeyword(t *testing.T) {
+	d := NewDialect("contextual")
+	d.AddKeywordFlags("async", kwAsync, Contextual|CaseInsensitive)
+	d.AddKeyword("func", kwFunc)
+	p := NewParser(d.NewLexer("contextual.test", []byte("Async func async(x) async")))
+
+	assert.Equal(t, IdentifierToken, p.Current().Token)
+	assert.False(t, p.AcceptKeyword(kwFunc))
+	assert.True(t, p.AcceptKeyword(kwAsync))
+	assert.Equal(t, kwAsync, p.Current().Token)
+	assert.Equal(t, "Async", p.Current().Value)
+	assert.Equal(t, `kw-async ("Async")`, p.Current().Identity())
+	assert.True(t, p.AcceptKeyword(kwAsync))
+
+	p.Next()
+	_, err := p.Expecting(kwFunc)
+	assert.NoError(t, err)
+
+	// Elsewhere, "async" remains an identifier.
+	p.Next()
+	symbol, err := p.Expecting(IdentifierToken, kwAsync)
+	if assert.NoError(t, err) {
+		assert.Equal(t, IdentifierToken, symbol.Token)
+	}
+
+	for p.Current().Value != ")" {
+		p.Next()
+	}
+	p.Next()
+	_, err = p.Expecting(kwFunc)
+	assert.EqualError(t, err, `contextual.test:1:21: syntax error: expected kw-func, got: "async"`)
+	symbol, err = p.Expecting(kwFunc, kwAsync)
+	if assert.NoError(t, err) {
+		assert.Equal(t, kwAsync, symbol.Token)
+		assert.Equal(t, "async", symbol.Value)
+	}
+}
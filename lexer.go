@@ -1,8 +1,8 @@
 package parsing
 
 import (
-	"bytes"
-	"fmt"
+	"io"
+	"unicode/utf8"
 )
 
 // EOFByte will be returned by Read and Peek when EOF is reached, but
@@ -19,19 +19,50 @@ type InterceptTable map[Token][]Intercept
 
 // Lexer tracks parsing of a source stream.
 type Lexer struct {
-	name       string
-	code       []byte
-	Start, End int // current Token bounds
-	Token      Token
-	keywords   map[string]Token
-	intercepts InterceptTable
+	name            string
+	code            []byte
+	dialect         *Dialect   // lexical rules of the source language
+	base            int        // offset of code[0] within the source
+	reader          io.Reader  // remaining source for streaming lexers
+	offsets         *offsetMap // original offsets of transcoded sources
+	retain          int        // lowest offset a streaming lexer must keep buffered
+	lines           []int      // offsets of the start of each line, see indexLines
+	indexed         int        // offset up to which lines have been indexed
+	Start, End      int        // current Token bounds
+	Token           Token
+	keywords        map[string]Token
+	flaggedKeywords map[string]flaggedKeyword // keywords with KeywordFlags
+	operators       []operator                // lexer-local operators, longest first
+	intercepts      InterceptTable
+	rules           *interceptSet // cursor intercepts, see AddInterceptRule
+	cursor          Cursor        // reused for each cursor intercept
+	modes           []*Dialect    // pushed modes, see PushMode
+	indent          *indentState  // indentation mode state, see SetIndentation
+	includeOptions  *IncludeOptions
+	file            *sourceFile     // included file being lexed, nil for the main file
+	includes        []includeFrame  // files containing the current include
+	fileSet         *FileSet        // registry of sources, see SetFileSet
+	setFile         *File           // file being lexed in fileSet
+	lineMap         []lineMapping   // line directives of the main file
+	interned        *internTable    // short words, see value
+	interning       bool            // whether words are interned, see SetInterning
+	zeroCopy        bool            // symbol values refer to the source, see SetZeroCopy
+	slab            symbolSlab      // storage for trivia
+	trivia          []Symbol        // trivia being collected by NextSymbol
+	pending         *pendingInclude // file to include at the next Advance
+	pins            []lexerPin      // offsets outstanding marks need buffered
+	markID          int             // id of the most recent mark
+	unicode         bool            // classify non-ASCII characters as UTF-8 runes
+	recovering      bool            // record errors rather than panicking
+	errors          []*LexError     // errors recorded in recovery mode
 }
 
 // Filename returns the name of the file this lexer is parsing.
 func (l *Lexer) Filename() string { return l.name }
 
-// Value returns the literal text of the current token.
-func (l *Lexer) Value() []byte { return l.code[l.Start:l.End] }
+// Value returns the literal text of the current token. For streaming lexers,
+// the slice is only valid until the next call to Advance.
+func (l *Lexer) Value() []byte { return l.code[l.Start-l.base : l.End-l.base] }
 
 // String returns a string copy of the literal value of the token.
 func (l *Lexer) String() string { return string(l.Value()) }
@@ -39,44 +70,43 @@ func (l *Lexer) String() string { return string(l.Value()) }
 // Position returns the start and end byte-offsets of the current token.
 func (l *Lexer) Position() (int, int) { return l.Start, l.End }
 
-// LineNo calculates the line-offset of a byte-offset within a source file.
-func (l *Lexer) LineNo(pos int) int {
-	return bytes.Count(l.code[:pos], []byte{'\n'}) + 1
-}
-
-// CharNo calculates the characters-from-start-of-line for a byte-offset within a source file.
-func (l *Lexer) CharNo(pos int) int {
-	lastCr := bytes.LastIndex(l.code[:pos], []byte{'\n'}) + 1
-	return pos - lastCr + 1
+// NewLexer constructs a new Lexer instance that follows the DefaultDialect.
+// Use Dialect.NewLexer for other languages. Byte-order marks are handled as
+// described by Dialect.NewLexer.
+func NewLexer(name string, code []byte) *Lexer {
+	return DefaultDialect.NewLexer(name, code)
 }
 
-// NewLexer constructs a new Lexer instance.
-func NewLexer(name string, code []byte) *Lexer {
-	return &Lexer{
-		name:       name,
-		code:       code,
-		Start:      0,
-		End:        0,
-		Token:      InvalidToken,
-		keywords:   nil,
-		intercepts: nil,
+// Dialect returns the lexical rules of the language this lexer is parsing,
+// see also Mode.
+func (l *Lexer) Dialect() *Dialect {
+	if l.dialect == nil {
+		return DefaultDialect
 	}
+	return l.dialect
 }
 
 // AddKeyword registers a keyword Terminal with lexer so that the lexer will recognize it
 // and return the specified token. Keyword identification is automatically performed on
-// "words" - tokens that start with a letter or underscore.
+// "words" - tokens that start with a letter or underscore. Keywords registered with the
+// lexer take precedence over those of its Dialect.
 func (l *Lexer) AddKeyword(keyword string, token Token) {
-	if !token.IsTerminal() {
-		panic(fmt.Sprintf("keywords must be represented by Terminals: %q is a Token", token.String()))
-	}
-	// Require a terminal rather than a token.
+	checkKeywordToken(token)
 	if l.keywords == nil {
 		l.keywords = make(map[string]Token)
 	}
 	l.keywords[keyword] = token
 }
 
+// SetUnicode enables or disables unicode mode. By default, the lexer classifies
+// source one byte at a time via TokenMap, so that any non-ASCII character is
+// an InvalidToken. In unicode mode, non-ASCII characters are decoded as UTF-8
+// and classified by ClassifyRune, allowing unicode identifiers and whitespace,
+// and malformed UTF-8 is reported as an error.
+func (l *Lexer) SetUnicode(enabled bool) {
+	l.unicode = enabled
+}
+
 // Intercept associates a token-identifier function with a particular token type. During
 // parsing, intercepts will be performed in the order they were registered.
 func (l *Lexer) AddIntercept(token Token, intercept Intercept) {
@@ -89,21 +119,17 @@ func (l *Lexer) AddIntercept(token Token, intercept Intercept) {
 // Read will attempt to get the next byte from the source code. ok will be false when
 // end-of-file is reached.
 func (l *Lexer) Read() (byte, bool) {
-	if l.End < len(l.code) {
-		char := l.code[l.End]
+	char, ok := l.byteAt(l.End)
+	if ok {
 		l.End++
-		return char, true
 	}
-	return EOFByte, false
+	return char, ok
 }
 
 // Peek will attempt to look at the next character in the source code without advancing
 // the read index. At EOF, ok will be false.
 func (l *Lexer) Peek() (byte, bool) {
-	if l.End < len(l.code) {
-		return l.code[l.End], true
-	}
-	return EOFByte, false
+	return l.byteAt(l.End)
 }
 
 // Skip moves the read pointer ahead one, it does not check for EOF.
@@ -120,13 +146,140 @@ func (l *Lexer) ForwardOn(char byte) bool {
 	return false
 }
 
-// Fatal reports a terminal parsing error at the current location in the file.
+// skipWhile advances over any characters that the dialect (or ClassifyRune, in
+// unicode mode) classifies as token.
+func (l *Lexer) skipWhile(dialect *Dialect, token Token) {
+	charMap := dialect.charMap
+	for rest := l.buffered(); len(rest) > 0; rest = l.buffered() {
+		idx := 0
+		for idx < len(rest) && (!l.unicode || rest[idx] < utf8.RuneSelf) && charMap[rest[idx]] == token {
+			idx++
+		}
+		l.End += idx
+		if idx == len(rest) {
+			continue
+		}
+		if !l.unicode || rest[idx] < utf8.RuneSelf {
+			return
+		}
+		r, size := l.peekRune(l.End)
+		if r == utf8.RuneError || ClassifyRune(r) != token {
+			return
+		}
+		l.End += size
+	}
+}
+
+// peekRune decodes the UTF-8 character at pos without consuming it. Invalid
+// encodings are returned as utf8.RuneError with a size of 1.
+func (l *Lexer) peekRune(pos int) (rune, int) {
+	var encoded [utf8.UTFMax]byte
+	n := 0
+	for ; n < len(encoded); n++ {
+		char, ok := l.byteAt(pos + n)
+		if !ok {
+			break
+		}
+		encoded[n] = char
+	}
+	return utf8.DecodeRune(encoded[:n])
+}
+
+// classifyRune decodes the multi-byte character at Start, of which the first
+// byte has already been consumed, and returns its base Token type.
+func (l *Lexer) classifyRune() Token {
+	r, size := l.peekRune(l.Start)
+	if r == utf8.RuneError && size <= 1 {
+		l.Fatal("invalid UTF-8 encoding")
+		return ErrorToken
+	}
+	l.End = l.Start + size
+	return ClassifyRune(r)
+}
+
+// validateUTF8 reports an error if the current token contains malformed UTF-8.
+func (l *Lexer) validateUTF8() {
+	value := l.Value()
+	for idx := 0; idx < len(value); {
+		r, size := utf8.DecodeRune(value[idx:])
+		if r == utf8.RuneError && size <= 1 {
+			l.fatalAt(l.Start+idx, l.Start+idx+1, "invalid UTF-8 encoding")
+			return
+		}
+		idx += size
+	}
+}
+
+// Fatal reports a parsing error at the current location in the file by
+// panicking with a *LexError. In recovery mode, the error is recorded, the
+// current token becomes an ErrorToken and Fatal returns.
 func (l *Lexer) Fatal(msg string, args ...interface{}) {
-	prefix := fmt.Sprintf("%s:%d:%d", l.name, l.LineNo(l.Start), l.CharNo(l.Start))
-	if l.End > l.Start+1 {
-		prefix += fmt.Sprintf("-%d:%d", l.LineNo(l.End), l.CharNo(l.End))
+	l.fatalAt(l.Start, l.End, msg, args...)
+}
+
+// fatalAt reports a parsing error at a specific range of the file.
+func (l *Lexer) fatalAt(start, end int, msg string, args ...interface{}) {
+	err := l.newError(start, end, msg, args...)
+	if !l.recovering {
+		panic(err)
+	}
+	l.errors = append(l.errors, err)
+	l.Token = ErrorToken
+}
+
+// hasPrefix returns true if the source at pos begins with prefix.
+func (l *Lexer) hasPrefix(pos int, prefix string) bool {
+	for idx := 0; idx < len(prefix); idx++ {
+		if char, ok := l.byteAt(pos + idx); !ok || char != prefix[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// symbolizeComment attempts to match one of the dialect's comment syntaxes
+// at the start of the current token. Line comments end before the newline.
+func (l *Lexer) symbolizeComment(dialect *Dialect) bool {
+	for _, style := range dialect.comments {
+		if !l.hasPrefix(l.Start, style.Open) || !l.atWordBoundary(dialect, style.Open) {
+			continue
+		}
+		l.End = l.Start + len(style.Open)
+		l.Token = CommentToken
+		if style.Close == "" {
+			for char, ok := l.Peek(); ok && char != '\n' && char != '\r'; char, ok = l.Peek() {
+				l.Skip()
+			}
+			return true
+		}
+		for depth := 1; depth > 0; {
+			switch {
+			case l.hasPrefix(l.End, style.Close):
+				l.End += len(style.Close)
+				depth--
+			case style.Nested && l.hasPrefix(l.End, style.Open):
+				l.End += len(style.Open)
+				depth++
+			default:
+				if _, ok := l.Read(); !ok {
+					l.Fatal("unterminated multiline comment")
+					return true
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// atWordBoundary checks that a word-like prefix at Start, such as "REM", is
+// not the beginning of a longer identifier.
+func (l *Lexer) atWordBoundary(dialect *Dialect, prefix string) bool {
+	if !dialect.IsIdentifierContinuation(prefix[len(prefix)-1]) {
+		return true
 	}
-	panic(fmt.Sprintf(prefix+": error: "+msg, args...))
+	next, ok := l.byteAt(l.Start + len(prefix))
+	return !ok || !dialect.IsIdentifierContinuation(next)
 }
 
 // SymbolizeComment will attempt to detect single- or multi-line comments and
@@ -163,6 +316,7 @@ func (l *Lexer) SymbolizeMultiLineComment() {
 		if char, ok := l.Read(); char != '*' {
 			if !ok {
 				l.Fatal("unterminated multiline comment")
+				return
 			}
 		} else if next, _ := l.Peek(); next == '/' {
 			l.Skip()
@@ -174,9 +328,10 @@ func (l *Lexer) SymbolizeMultiLineComment() {
 // SymbolizeString handles quoted strings by searching for the corresponding
 // close quote character with allowing for escaped quotes (\', \").
 // Does not validate escape sequences,
-// Considers end-of-line before close-quote an error,
-// Considers end-of-file before close-quote as fatal,
+// Considers end-of-line or end-of-file before close-quote an error (see Fatal),
+// in which case the token ends before the end-of-line.
 func (l *Lexer) SymbolizeString() {
+	quote, _ := l.byteAt(l.Start)
 loop:
 	for {
 		char, ok := l.Read()
@@ -186,10 +341,8 @@ loop:
 				break loop
 			}
 
-		case '\'', '"':
-			if char == l.code[l.Start] {
-				return
-			}
+		case quote:
+			return
 
 		case '\\':
 			char, ok := l.Peek()
@@ -200,6 +353,7 @@ loop:
 			break loop
 
 		case '\n', '\r':
+			l.End--
 			break loop
 		}
 	}
@@ -209,100 +363,167 @@ loop:
 // SymbolizeWord consumes a token starting with a letter or underscore and
 // classifies using optional keyword lookups or as IdentifierToken or IdentifierToken.
 func (l *Lexer) SymbolizeWord() {
+	l.symbolizeWord(l.Mode())
+}
+
+// symbolizeWord is SymbolizeWord for the lexer's current mode.
+func (l *Lexer) symbolizeWord(dialect *Dialect) {
 	// Try and consume as an identifier, which requires we do not End on an alpha.
-	for l.End < len(l.code) && IsIdentifierContinuation(l.code[l.End]) {
-		l.Skip()
+	for rest := l.buffered(); len(rest) > 0; rest = l.buffered() {
+		idx := 0
+		for idx < len(rest) && (!l.unicode || rest[idx] < utf8.RuneSelf) && dialect.IsIdentifierContinuation(rest[idx]) {
+			idx++
+		}
+		l.End += idx
+		if idx == len(rest) {
+			continue
+		}
+		if !l.unicode || rest[idx] < utf8.RuneSelf {
+			break
+		}
+		r, size := l.peekRune(l.End)
+		if r == utf8.RuneError || !IsIdentifierContinuationRune(r) {
+			break
+		}
+		l.End += size
 	}
 	if l.End > l.Start {
 		l.Token = IdentifierToken
-		if l.keywords != nil {
-			if keyword, ok := l.keywords[l.String()]; ok {
-				l.Token = keyword
-			}
+		if keyword, ok := l.reservedKeyword(dialect, l.Value()); ok {
+			l.Token = keyword
 		}
 	}
 }
 
 // SymbolizeNumber attempts to classify numeric-looking tokens as either
-// IntegerToken or FloatToken.
+// IntegerToken or FloatToken. The dialect's NumberSyntax determines which
+// forms beyond <digits> [. [<digits>]] are accepted.
 func (l *Lexer) SymbolizeNumber() {
-	// allow <digits> [. [<digits>]]]
-	for l.End < len(l.code) && TokenMap[l.code[l.End]] == DigitToken {
-		l.Skip()
+	l.symbolizeNumber(l.Mode())
+}
+
+// symbolizeNumber is SymbolizeNumber for the lexer's current mode.
+func (l *Lexer) symbolizeNumber(dialect *Dialect) {
+	if dialect.numbers&(HexNumbers|OctalNumbers|BinaryNumbers) != 0 && l.symbolizeRadixNumber(dialect) {
+		return
 	}
-	if l.End < len(l.code) && TokenMap[l.code[l.End]] == Period {
+	// allow <digits> [. [<digits>]]]
+	l.skipDigits(dialect, 10)
+	if char, ok := l.Peek(); ok && dialect.charMap[char] == Period {
 		l.Token = FloatToken
 		l.Skip()
-		for l.End < len(l.code) && TokenMap[l.code[l.End]] == DigitToken {
-			l.Skip()
-		}
+		l.skipDigits(dialect, 10)
 	} else {
 		l.Token = IntegerToken
 	}
+	if dialect.numbers&Exponents != 0 {
+		l.symbolizeExponent(dialect)
+	}
+	l.symbolizeNumberSuffix(dialect)
 }
 
-// attempt to apply intercept rules.
-func (l *Lexer) intercept() bool {
-	// Don't trust the user not to tamper properties of lexer.
-	lCopy := *l
-	if intercepts, ok := l.intercepts[l.Token]; ok {
-		for _, intercept := range intercepts {
-			if intercept(&lCopy) {
-				// accept any changes.
-				*l = lCopy
-				return true
+// Advance will try to classify the next token in the stream.
+func (l *Lexer) Advance() bool {
+	for {
+		if l.pending != nil {
+			l.beginInclude()
+		}
+		content := l.indent != nil && l.indent.content
+		var ok bool
+		if l.indent != nil {
+			ok = l.advanceIndented()
+		} else {
+			ok = l.advance()
+		}
+		if ok {
+			if l.directive() && l.indent != nil {
+				// The directive doesn't end a logical line.
+				l.indent.content = content
 			}
+			return true
 		}
+		if len(l.includes) == 0 {
+			return false
+		}
+		l.endInclude()
 	}
-	return false
 }
 
-// Advance will try to classify the next token in the stream.
-func (l *Lexer) Advance() bool {
-	l.Start = l.End
-	if l.End >= len(l.code) {
-		l.Token = EOFToken
+// directive recognizes include and line directives at the current token.
+func (l *Lexer) directive() bool {
+	lineDirectives := len(l.Dialect().lineDirectives) > 0
+	if (l.includeOptions == nil && !lineDirectives) || !l.inBaseMode() || l.Token == WhitespaceToken || l.Token == NewlineToken {
 		return false
 	}
+	if l.includeOptions != nil && l.includeOptions.Directive != "" && IsSignificant(l.Token) && l.includeDirective() {
+		return true
+	}
+	return lineDirectives && l.lineDirective()
+}
+
+// advance classifies the next token without regard to indentation.
+func (l *Lexer) advance() bool {
+	l.Start = l.End
 
 	// Move Start to the beginning of the new token, capture the character and move the
 	// read token beyond it.
-	char := l.code[l.Start]
-	l.Skip()
+	char, ok := l.Read()
+	if !ok {
+		l.Token = EOFToken
+		return l.interceptEOF()
+	}
 
-	l.Token = TokenMap[char]
-	if l.intercepts != nil && l.intercept() {
+	dialect := l.Mode()
+	l.Token = dialect.charMap[char]
+	if l.unicode && char >= utf8.RuneSelf {
+		l.Token = l.classifyRune()
+	}
+	if (l.intercepts != nil || l.rules != nil || dialect.intercepts != nil || dialect.rules != nil) && l.intercept() {
+		return true
+	}
+
+	if dialect.commentStarts[char] && l.symbolizeComment(dialect) {
+		if l.unicode {
+			l.validateUTF8()
+		}
+		return true
+	}
+
+	if dialect.patterns != nil {
+		// The DFA has already considered the dialect's operators.
+		if l.symbolizePattern(dialect.dfa()) || (l.operators != nil && l.inBaseMode() && l.symbolizeOperator(nil)) {
+			return true
+		}
+	} else if ((l.operators != nil && l.inBaseMode()) || dialect.operatorStarts[char]) && l.symbolizeOperator(dialect) {
 		return true
 	}
 
 	switch l.Token {
 	case WhitespaceToken, NewlineToken:
-		for l.End < len(l.code) && TokenMap[l.code[l.End]] == l.Token {
-			l.Skip()
-		}
+		l.skipWhile(dialect, l.Token)
 
 	case AlphaToken, Underscore:
-		l.SymbolizeWord()
-
-	case Slash:
-		l.SymbolizeComment()
+		l.symbolizeWord(dialect)
 
 	case StringToken:
-		l.SymbolizeString()
+		l.symbolizeString(dialect)
+		if l.unicode {
+			l.validateUTF8()
+		}
 
 	case DigitToken:
-		l.SymbolizeNumber()
+		l.symbolizeNumber(dialect)
 
 	case Plus, Minus:
-		if l.End < len(l.code) && IsNumeric(l.code[l.End]) {
-			l.SymbolizeNumber()
+		if next, ok := l.Peek(); ok && dialect.isNumeric(next) {
+			l.symbolizeNumber(dialect)
 		}
 
 	case Period:
-		if l.End < len(l.code) && TokenMap[l.code[l.End]] == DigitToken {
+		if next, ok := l.Peek(); ok && dialect.charMap[next] == DigitToken {
 			// Let symbolize number see the decimal
 			l.End--
-			l.SymbolizeNumber()
+			l.symbolizeNumber(dialect)
 		}
 	}
 
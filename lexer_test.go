@@ -121,6 +121,7 @@ func Test_NewLexer(t *testing.T) {
 	assert.Equal(t, InvalidToken, lexer.Token)
 	assert.Nil(t, lexer.keywords)
 	assert.Nil(t, lexer.intercepts)
+	assert.Equal(t, DefaultDialect, lexer.dialect)
 }
 
 func TestLexer_AddKeyword(t *testing.T) {
@@ -281,17 +282,17 @@ func TestLexer_ForwardOn(t *testing.T) {
 func TestLexer_Fatal(t *testing.T) {
 	t.Run("range", func(t *testing.T) {
 		l := &Lexer{name: "mytest.txt", code: []byte("\nhello"), Start: 1, End: 6}
-		assert.PanicsWithValue(t, "mytest.txt:2:1-2:6: error: goes boom", func() { l.Fatal("goes %s", "boom") })
+		assert.PanicsWithError(t, "mytest.txt:2:1-2:6: error: goes boom", func() { l.Fatal("goes %s", "boom") })
 	})
 
 	t.Run("0-point", func(t *testing.T) {
 		l := &Lexer{name: "aaa", code: []byte("\nhello"), Start: 0, End: 0}
-		assert.PanicsWithValue(t, "aaa:1:1: error: badda-boom", func() { l.Fatal("%s-boom", "badda") })
+		assert.PanicsWithError(t, "aaa:1:1: error: badda-boom", func() { l.Fatal("%s-boom", "badda") })
 	})
 
 	t.Run("1-point", func(t *testing.T) {
 		l := &Lexer{name: "stupid:name:for:a:file:", code: []byte("\nhello"), Start: 4, End: 5}
-		assert.PanicsWithValue(t, "stupid:name:for:a:file::2:4: error: multipass", func() { l.Fatal("multipass") })
+		assert.PanicsWithError(t, "stupid:name:for:a:file::2:4: error: multipass", func() { l.Fatal("multipass") })
 	})
 }
 
@@ -548,3 +549,69 @@ func TestLexer_Advance(t *testing.T) {
 		})
 	})
 }
+
+func TestLexer_SetUnicode(t *testing.T) {
+	lex := func(l *Lexer) (tokens []Token, values []string) {
+		for l.Advance() {
+			tokens = append(tokens, l.Token)
+			values = append(values, l.String())
+		}
+		return
+	}
+
+	code := "café\u00a0名前_1\u2028x «y»"
+	t.Run("disabled", func(t *testing.T) {
+		tokens, _ := lex(NewLexer("bytes.test", []byte(code)))
+		assert.Contains(t, tokens, InvalidToken)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		l := NewLexer("unicode.test", []byte(code))
+		l.SetUnicode(true)
+		tokens, values := lex(l)
+		assert.Equal(t, []Token{
+			IdentifierToken, WhitespaceToken, IdentifierToken, NewlineToken, IdentifierToken,
+			WhitespaceToken, SymbolToken, IdentifierToken, SymbolToken,
+		}, tokens)
+		assert.Equal(t, []string{"café", "\u00a0", "名前_1", "\u2028", "x", " ", "«", "y", "»"}, values)
+	})
+
+	t.Run("mixed whitespace", func(t *testing.T) {
+		l := NewLexer("unicode.test", []byte(" \u00a0\t\u3000x"))
+		l.SetUnicode(true)
+		if assert.True(t, l.Advance()) && assert.Equal(t, WhitespaceToken, l.Token) {
+			assert.Equal(t, 0, l.Start)
+			assert.Equal(t, 7, l.End)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		tests := []struct {
+			name, code, err string
+		}{
+			{"lead byte", "ab \xff", "malformed.test:1:4: error: invalid UTF-8 encoding"},
+			{"truncated", "\n\xe5\x90", "malformed.test:2:1: error: invalid UTF-8 encoding"},
+			{"in identifier", "caf\xc3(", "malformed.test:1:4: error: invalid UTF-8 encoding"},
+			{"in string", "x = 'ab\xc0\xafc'", "malformed.test:1:8: error: invalid UTF-8 encoding"},
+			{"in comment", "/* \xed\xa0\x80 */", "malformed.test:1:4: error: invalid UTF-8 encoding"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				l := NewLexer("malformed.test", []byte(tt.code))
+				l.SetUnicode(true)
+				assert.PanicsWithError(t, tt.err, func() { lex(l) })
+			})
+		}
+	})
+}
+
+func BenchmarkLexer_Advance(b *testing.B) {
+	code := benchmarkSource[:10*1024]
+	b.SetBytes(int64(len(code)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := NewLexer("bench", code)
+		for l.Advance() {
+		}
+	}
+}
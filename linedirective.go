@@ -0,0 +1,149 @@
+package parsing
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Line directives let a generated source describe where its text came from,
+// so that errors point at the template rather than the generated file. A
+// directive occupies a line of its own and names the file and line number of
+// the line that follows it, in either of two forms:
+//
+//	//line template.tmpl:12
+//	#line 12 "template.tmpl"
+//
+// The file may be omitted from the second form, leaving it unchanged. The
+// lexer returns a directive as a DirectiveToken. Locate, Errorf and LexErrors
+// then report the mapped file and line, while offsets, LineNo and CharNo
+// remain those of the source being lexed.
+
+// lineMapping records that the line of a source beginning at offset, which
+// is line rawLine of the source, is line line of file.
+type lineMapping struct {
+	offset  int
+	rawLine int
+	file    string
+	line    int
+}
+
+// AddLineDirective registers the prefix of a line directive, such as "#line"
+// or "//line", with the dialect. The prefix must be followed by whitespace.
+func (d *Dialect) AddLineDirective(prefix string) {
+	if prefix == "" {
+		panic("line directives must have a prefix")
+	}
+	d.lineDirectives = append(d.lineDirectives, prefix)
+}
+
+// lineDirective recognizes a line directive at the current token, replacing
+// the token with a DirectiveToken that extends to the end of the line, and
+// records the mapping it describes.
+func (l *Lexer) lineDirective() bool {
+	if !l.atLineStart(l.Start) {
+		return false
+	}
+	for _, prefix := range l.Dialect().lineDirectives {
+		if !l.hasPrefix(l.Start, prefix) {
+			continue
+		}
+		if char, ok := l.byteAt(l.Start + len(prefix)); !ok || (char != ' ' && char != '\t') {
+			continue
+		}
+		end := l.Start + len(prefix)
+		for char, ok := l.byteAt(end); ok && char != '\n'; char, ok = l.byteAt(end) {
+			end++
+		}
+		text, _ := l.Slice(l.Start+len(prefix), end)
+		l.End, l.Token = end, DirectiveToken
+		file, line, ok := parseLineDirective(string(text))
+		if !ok {
+			l.fatalAt(l.Start, l.End, "invalid line directive: %s", strings.TrimSpace(string(text)))
+			return true
+		}
+		mappings := &l.lineMap
+		if l.file != nil {
+			mappings = &l.file.lineMap
+		}
+		if file == "" {
+			file, _ = mapLine(*mappings, l.name, l.LineNo(l.Start), l.Start)
+		}
+		// The mapping begins with the line after the directive.
+		next := end + 1
+		if _, ok := l.byteAt(end); !ok {
+			next = end
+		}
+		*mappings = append(*mappings, lineMapping{offset: next, rawLine: l.LineNo(l.Start) + 1, file: file, line: line})
+		if l.setFile != nil {
+			l.setFile.AddLineInfo(next, file, line)
+		}
+		return true
+	}
+	return false
+}
+
+// atLineStart returns true if only spaces and tabs precede pos on its line.
+func (l *Lexer) atLineStart(pos int) bool {
+	for pos--; pos >= 0; pos-- {
+		char, ok := l.byteAt(pos)
+		if !ok || char == '\n' {
+			return true
+		}
+		if char != ' ' && char != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLineDirective parses the text following the prefix of a line
+// directive as "file:line" or "line" or `line "file"`.
+func parseLineDirective(text string) (file string, line int, ok bool) {
+	text = strings.TrimSpace(text)
+	if text != "" && text[0] >= '0' && text[0] <= '9' {
+		number, rest := text, ""
+		if idx := strings.IndexAny(text, " \t"); idx >= 0 {
+			number, rest = text[:idx], strings.TrimSpace(text[idx:])
+		}
+		if rest != "" {
+			var err error
+			if file, err = strconv.Unquote(rest); err != nil || file == "" {
+				return "", 0, false
+			}
+		}
+		line, err := strconv.Atoi(number)
+		return file, line, err == nil && line > 0
+	}
+	idx := strings.LastIndexByte(text, ':')
+	if idx <= 0 {
+		return "", 0, false
+	}
+	line, err := strconv.Atoi(text[idx+1:])
+	return text[:idx], line, err == nil && line > 0
+}
+
+// mapLine applies the line directives of a source, named name, to line
+// number line, which contains offset.
+func mapLine(mappings []lineMapping, name string, line, offset int) (string, int) {
+	for idx := len(mappings) - 1; idx >= 0; idx-- {
+		if mapping := mappings[idx]; mapping.offset <= offset {
+			return mapping.file, mapping.line + line - mapping.rawLine
+		}
+	}
+	return name, line
+}
+
+// lineMapOf returns the line directives of the file origin, or of the
+// lexer's main file if origin is nil.
+func (l *Lexer) lineMapOf(origin *sourceFile) []lineMapping {
+	if origin == nil {
+		return l.lineMap
+	}
+	return origin.lineMap
+}
+
+// mappedLocation describes offset in the file being lexed, after applying
+// line directives, for LexErrors.
+func (l *Lexer) mappedLocation(offset int) (file string, line int) {
+	return mapLine(l.lineMapOf(l.file), l.name, l.LineNo(offset), offset)
+}
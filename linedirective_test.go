@@ -0,0 +1,115 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseLineDirective(t *testing.T) {
+	tests := []struct {
+		text string
+		file string
+		line int
+		ok   bool
+	}{
+		{" tmpl.t:12", "tmpl.t", 12, true},
+		{"\tC:\\src\\tmpl.t:3\r", "C:\\src\\tmpl.t", 3, true},
+		{" 12", "", 12, true},
+		{" 12 \"tmpl.t\"", "tmpl.t", 12, true},
+		{"", "", 0, false},
+		{" tmpl.t", "", 0, false},
+		{" :12", "", 0, false},
+		{" tmpl.t:0", "", 0, false},
+		{" tmpl.t:x", "", 0, false},
+		{" 12 tmpl.t", "", 0, false},
+		{" 12 \"\"", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			file, line, ok := parseLineDirective(tt.text)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.file, file)
+				assert.Equal(t, tt.line, line)
+			}
+		})
+	}
+}
+
+// lineDirectiveDialect returns a dialect with "//line" and "#line" directives.
+func lineDirectiveDialect() *Dialect {
+	d := NewDialect("line-directives")
+	d.AddLineDirective("//line")
+	d.AddLineDirective("#line")
+	return d
+}
+
+func TestDialect_AddLineDirective(t *testing.T) {
+	const code = "a\n//line tmpl.t:10\nb x //line other.t:1\n  #line 20\n\nc\n#line 5 \"gen.t\"\nd //linear\n\"e"
+	l := lineDirectiveDialect().NewLexer("generated.go", []byte(code))
+	l.SetRecovery(true)
+	set := NewFileSet()
+	l.SetFileSet(set)
+	p := NewParser(l)
+
+	var locations []string
+	for !p.EOF() {
+		locations = append(locations, p.Current().Value+"@"+p.Locate(p.Current()))
+		if p.Current().Value == "c" {
+			assert.Equal(t, "tmpl.t:21:1: unexpected: \"c\"", p.Errorf(p.Current(), "unexpected").Error())
+			assert.Equal(t, 6, l.LineNo(p.Current().StartOffset))
+			adjusted := set.Position(p.Current().Pos)
+			assert.Equal(t, "tmpl.t:21:1", adjusted.String())
+			assert.Equal(t, "generated.go:6:1", set.PositionFor(p.Current().Pos, false).String())
+		}
+		p.Next()
+	}
+	assert.Equal(t, []string{"a@generated.go:1:1", "b@tmpl.t:10:1", "x@tmpl.t:10:3", "c@tmpl.t:21:1", "d@gen.t:5:1", "\"e@gen.t:6:1"}, locations)
+
+	require.Len(t, l.Errors(), 1)
+	err := l.Errors()[0]
+	assert.Equal(t, "gen.t", err.Filename)
+	assert.Equal(t, 6, err.StartLine)
+	assert.Equal(t, len(code)-2, err.Start)
+	assert.Equal(t, "gen.t:6:1-6:3: error: unterminated string/missing close-quote?", err.Error())
+}
+
+func TestLexer_lineDirective(t *testing.T) {
+	t.Run("tokens", func(t *testing.T) {
+		l := lineDirectiveDialect().NewLexer("tokens.go", []byte("//line a.t:1\r\n#line 2 \"b.t\"\nx //line c.t:3"))
+		var tokens []Token
+		var values []string
+		for l.Advance() {
+			tokens = append(tokens, l.Token)
+			values = append(values, l.String())
+		}
+		assert.Equal(t, []Token{DirectiveToken, NewlineToken, DirectiveToken, NewlineToken, IdentifierToken, WhitespaceToken, CommentToken}, tokens)
+		assert.Equal(t, "//line a.t:1\r", values[0])
+		assert.Equal(t, "#line 2 \"b.t\"", values[2])
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		l := lineDirectiveDialect().NewLexer("invalid.go", []byte("x\n#line nowhere\n"))
+		assert.True(t, l.Advance())
+		assert.True(t, l.Advance())
+		assert.PanicsWithError(t, "invalid.go:2:1-2:14: error: invalid line directive: nowhere", func() { l.Advance() })
+	})
+
+	t.Run("included", func(t *testing.T) {
+		files := map[string]string{
+			"main.cfg": "include \"inc.cfg\"\nz",
+			"inc.cfg":  "#line 7 \"tmpl.t\"\ny",
+		}
+		d := lineDirectiveDialect()
+		l := d.NewLexer("main.cfg", []byte(files["main.cfg"]))
+		l.SetIncludes(IncludeOptions{Directive: "include", ReadFile: fileMap(files)})
+		p := NewParser(l)
+		require.Equal(t, "y", p.Current().Value)
+		assert.Equal(t, "tmpl.t:7:1: unexpected: \"y\"\n\tincluded from main.cfg:1:1", p.Errorf(p.Current(), "unexpected").Error())
+		p.Next()
+		require.Equal(t, "z", p.Current().Value)
+		assert.Equal(t, "main.cfg:2:1", p.Locate(p.Current()))
+	})
+}
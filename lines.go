@@ -0,0 +1,68 @@
+package parsing
+
+import (
+	"bytes"
+	"sort"
+)
+
+// The lexer maintains an index of the offsets at which each line begins so
+// that position queries don't have to rescan the source. The index is built
+// lazily, as far as the furthest position queried, and for streaming lexers
+// it is also brought up to date before any of the buffer is discarded.
+
+// indexLines records the start of any lines beginning at or before pos that
+// have not already been indexed.
+func (l *Lexer) indexLines(pos int) {
+	if l.lines == nil {
+		l.lines = make([]int, 1, 64)
+	}
+	if end := l.base + len(l.code); pos > end {
+		pos = end
+	}
+	for l.indexed < pos {
+		idx := bytes.IndexByte(l.code[l.indexed-l.base:pos-l.base], '\n')
+		if idx < 0 {
+			l.indexed = pos
+			break
+		}
+		l.indexed += idx + 1
+		l.lines = append(l.lines, l.indexed)
+	}
+}
+
+// lineIndex returns the 0-based index of the line containing pos.
+func (l *Lexer) lineIndex(pos int) int {
+	l.indexLines(pos)
+	return sort.Search(len(l.lines), func(i int) bool { return l.lines[i] > pos }) - 1
+}
+
+// LineNo calculates the line-offset of a byte-offset within a source file.
+func (l *Lexer) LineNo(pos int) int {
+	return l.lineIndex(pos) + 1
+}
+
+// CharNo calculates the characters-from-start-of-line for a byte-offset within a source file.
+func (l *Lexer) CharNo(pos int) int {
+	return pos - l.lines[l.lineIndex(pos)] + 1
+}
+
+// LineText returns the text of a line, by line number, without its line
+// terminator. ok is false if there is no such line or, for streaming lexers,
+// if the line is no longer or not yet buffered.
+func (l *Lexer) LineText(lineNo int) (text []byte, ok bool) {
+	bufferEnd := l.base + len(l.code)
+	l.indexLines(bufferEnd)
+	if lineNo < 1 || lineNo > len(l.lines) || l.lines[lineNo-1] < l.base {
+		return nil, false
+	}
+	start, end := l.lines[lineNo-1], bufferEnd
+	if lineNo < len(l.lines) {
+		end = l.lines[lineNo]
+	} else if l.reader != nil {
+		// The remainder of the line hasn't been read yet.
+		return nil, false
+	}
+	text = l.code[start-l.base : end-l.base]
+	text = bytes.TrimSuffix(text, []byte{'\n'})
+	return bytes.TrimSuffix(text, []byte{'\r'}), true
+}
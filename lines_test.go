@@ -0,0 +1,77 @@
+package parsing
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLexer_indexLines(t *testing.T) {
+	l := NewLexer("index.test", []byte("a\nbb\n\nccc\n"))
+	l.indexLines(3)
+	if assert.Equal(t, []int{0, 2}, l.lines) {
+		assert.Equal(t, 3, l.indexed)
+	}
+	// Indexing is incremental and never goes backwards.
+	l.indexLines(1)
+	assert.Equal(t, []int{0, 2}, l.lines)
+	l.indexLines(100)
+	if assert.Equal(t, []int{0, 2, 5, 6, 10}, l.lines) {
+		assert.Equal(t, 10, l.indexed)
+	}
+}
+
+func TestLexer_LineNo_matchesScan(t *testing.T) {
+	code := []byte(strings.Repeat("one\ntwo three\r\n\n  four\n", 50))
+	l := NewLexer("scan.test", code)
+	// Query in a non-linear order to exercise the lazy index.
+	for _, pos := range []int{len(code) / 2, 0, len(code), 17, len(code) - 1, 3, 4} {
+		wantLine := bytes.Count(code[:pos], []byte{'\n'}) + 1
+		wantChar := pos - (bytes.LastIndexByte(code[:pos], '\n') + 1) + 1
+		assert.Equal(t, wantLine, l.LineNo(pos), "LineNo(%d)", pos)
+		assert.Equal(t, wantChar, l.CharNo(pos), "CharNo(%d)", pos)
+	}
+}
+
+func TestLexer_LineText(t *testing.T) {
+	l := NewLexer("text.test", []byte("first\r\nsecond\n\nlast"))
+	tests := []struct {
+		lineNo int
+		want   string
+		ok     bool
+	}{
+		{0, "", false},
+		{1, "first", true},
+		{2, "second", true},
+		{3, "", true},
+		{4, "last", true},
+		{5, "", false},
+	}
+	for _, tt := range tests {
+		text, ok := l.LineText(tt.lineNo)
+		if assert.Equal(t, tt.ok, ok, "line %d", tt.lineNo) {
+			assert.Equal(t, tt.want, string(text), "line %d", tt.lineNo)
+		}
+	}
+}
+
+func TestReaderLexer_positions(t *testing.T) {
+	code := strings.Repeat("alpha beta\n", 100)
+	withChunkSize(16, func() {
+		l := NewReaderLexer("stream.test", strings.NewReader(code))
+		for l.Advance() {
+		}
+		// The start of the stream has long since been discarded, but
+		// positions within it can still be resolved.
+		assert.Equal(t, 1, l.LineNo(0))
+		assert.Equal(t, 2, l.LineNo(17))
+		assert.Equal(t, 7, l.CharNo(17))
+		assert.Equal(t, 100, l.LineNo(len(code)-1))
+		assert.Equal(t, 101, l.LineNo(len(code)))
+
+		_, ok := l.LineText(1)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,196 @@
+package parsing
+
+// LexerMark is an opaque checkpoint of a Lexer's state, see Lexer.Mark.
+type LexerMark struct {
+	id         int
+	start, end int
+	token      Token
+	modes      []*Dialect
+	errors     int
+	indent     *indentState
+	file       *sourceFile
+	pending    *pendingInclude
+}
+
+// lexerPin keeps a streaming lexer's source buffered from offset onwards for
+// as long as the mark with the same id is outstanding.
+type lexerPin struct {
+	id, offset int
+}
+
+// Mark returns a checkpoint of the lexer's position, current token, mode
+// stack, indentation, include stack and recorded errors that Reset can return
+// to. For streaming lexers, the source from the current token onwards remains
+// buffered until the mark is released with Commit.
+func (l *Lexer) Mark() LexerMark {
+	l.markID++
+	l.pins = append(l.pins, lexerPin{l.markID, l.Start})
+	return LexerMark{
+		id:      l.markID,
+		start:   l.Start,
+		end:     l.End,
+		token:   l.Token,
+		modes:   append([]*Dialect(nil), l.modes...),
+		errors:  len(l.errors),
+		indent:  l.indent.copy(),
+		file:    l.file,
+		pending: l.pending,
+	}
+}
+
+// plainMark is a Mark of only the position and current token, for lexers
+// that have no other state to restore, see stateless. Plain marks don't keep
+// the source buffered and needn't be committed.
+func (l *Lexer) plainMark() LexerMark {
+	return LexerMark{id: -1, start: l.Start, end: l.End, token: l.Token}
+}
+
+// stateless returns true if the position and current token are the only
+// state that lexing can change: the source is in memory, and the lexer is in
+// its base mode with no intercepts, indentation, includes or error recovery.
+func (l *Lexer) stateless() bool {
+	dialect := l.Dialect()
+	return l.reader == nil && l.inBaseMode() && l.indent == nil && l.includeOptions == nil && !l.recovering &&
+		l.intercepts == nil && l.rules == nil && dialect.intercepts == nil && dialect.rules == nil
+}
+
+// Reset returns the lexer to the state captured by mark, discarding any errors
+// recorded since. The mark remains valid, so that several alternatives can be
+// tried from it, until it is released by Commit. If the lexer has entered or
+// left included files since the mark, it returns to the file the mark was
+// taken in, see SetIncludes.
+func (l *Lexer) Reset(mark LexerMark) {
+	if mark.file != l.file {
+		l.resetIncludes(mark.file)
+	}
+	l.Start, l.End, l.Token = mark.start, mark.end, mark.token
+	l.modes = append(l.modes[:0], mark.modes...)
+	if mark.errors < len(l.errors) {
+		l.errors = l.errors[:mark.errors]
+	}
+	l.indent = mark.indent.copy()
+	l.pending = mark.pending
+}
+
+// resetIncludes returns the lexer to file, rebuilding the include stack from
+// the state each file saved of the one including it. Only the main file may
+// be streamed, and it keeps its current buffer, which holds anything read
+// from the stream since.
+func (l *Lexer) resetIncludes(file *sourceFile) {
+	main := l.frame()
+	if len(l.includes) > 0 {
+		main = l.includes[0]
+	}
+	depth := 0
+	for f := file; f != nil; f = f.parent {
+		depth++
+	}
+	l.includes = append(l.includes[:0], make([]includeFrame, depth)...)
+	for f := file; f != nil; f = f.parent {
+		depth--
+		l.includes[depth] = f.including
+		l.includes[depth].indent = f.including.indent.copy()
+	}
+	current := main
+	if file != nil {
+		current = includeFrame{file: file, name: file.name, code: file.code, offsets: file.offsets,
+			lines: file.lines, indexed: len(file.code), setFile: file.setFile}
+		frame := &l.includes[0]
+		frame.code, frame.base, frame.reader, frame.offsets = main.code, main.base, main.reader, main.offsets
+		frame.retain, frame.lines, frame.indexed = main.retain, main.lines, main.indexed
+	}
+	l.restoreFrame(current)
+}
+
+// Commit releases a mark once it is no longer needed, without changing the
+// state of the lexer.
+func (l *Lexer) Commit(mark LexerMark) {
+	for idx, pin := range l.pins {
+		if pin.id == mark.id {
+			l.pins = append(l.pins[:idx], l.pins[idx+1:]...)
+			return
+		}
+	}
+}
+
+// pinned returns the lowest offset that an outstanding mark requires to
+// remain buffered, or limit if it is lower.
+func (l *Lexer) pinned(limit int) int {
+	for _, pin := range l.pins {
+		if pin.offset < limit {
+			limit = pin.offset
+		}
+	}
+	return limit
+}
+
+// retainMark keeps the source of a mark buffered until a further Commit.
+func (l *Lexer) retainMark(mark LexerMark) {
+	if mark.id > 0 {
+		l.pins = append(l.pins, lexerPin{mark.id, mark.start})
+	}
+}
+
+// ParserMark is an opaque checkpoint of a Parser's state, see Parser.Mark.
+type ParserMark struct {
+	lexer      LexerMark
+	current    *Symbol
+	ahead      []*Symbol
+	aheadMarks []LexerMark // retained by the mark, see retainMark
+	// saved are copies of current and ahead, since rules modify symbols in place.
+	saved []Symbol
+}
+
+// Mark returns a checkpoint of the parser's current symbol, its read-ahead
+// buffer and the state of its Lexer, so that the parser can backtrack after
+// trying an alternative by calling Reset. Marks may be nested.
+func (p *Parser) Mark() ParserMark {
+	mark := ParserMark{
+		lexer:      p.Lexer.Mark(),
+		current:    p.current,
+		ahead:      append([]*Symbol(nil), p.ahead...),
+		aheadMarks: append([]LexerMark(nil), p.aheadMarks...),
+		saved:      make([]Symbol, 0, len(p.ahead)+1),
+	}
+	for _, aheadMark := range mark.aheadMarks {
+		p.Lexer.retainMark(aheadMark)
+	}
+	if p.current != nil {
+		mark.saved = append(mark.saved, *p.current)
+	}
+	for _, symbol := range p.ahead {
+		mark.saved = append(mark.saved, *symbol)
+	}
+	return mark
+}
+
+// Reset returns the parser to the state captured by mark, regardless of how
+// far it has read ahead since, undoing any rules that have been applied. The
+// mark remains valid until it is released by Commit.
+func (p *Parser) Reset(mark ParserMark) {
+	p.Lexer.Reset(mark.lexer)
+	for _, aheadMark := range p.aheadMarks {
+		p.Lexer.Commit(aheadMark)
+	}
+	for _, aheadMark := range mark.aheadMarks {
+		p.Lexer.retainMark(aheadMark)
+	}
+	p.current = mark.current
+	p.ahead = append(p.ahead[:0:0], mark.ahead...)
+	p.aheadMarks = append(p.aheadMarks[:0:0], mark.aheadMarks...)
+	saved := mark.saved
+	if p.current != nil {
+		*p.current, saved = saved[0], saved[1:]
+	}
+	for idx, symbol := range p.ahead {
+		*symbol = saved[idx]
+	}
+}
+
+// Commit releases a mark once the parser has settled on an alternative.
+func (p *Parser) Commit(mark ParserMark) {
+	p.Lexer.Commit(mark.lexer)
+	for _, aheadMark := range mark.aheadMarks {
+		p.Lexer.Commit(aheadMark)
+	}
+}
@@ -0,0 +1,166 @@
+package parsing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLexer_Mark(t *testing.T) {
+	expr := NewDialect("expr")
+	l := NewLexer("mark.test", []byte("alpha beta 'gamma delta"))
+	l.SetRecovery(true)
+	require.True(t, l.Advance())
+	mark := l.Mark()
+	assert.Len(t, l.pins, 1)
+
+	l.PushMode(expr)
+	tokens, values := lexTokens(l)
+	assert.Equal(t, []Token{IdentifierToken, ErrorToken}, tokens)
+	assert.Equal(t, []string{"beta", "'gamma delta"}, values)
+	assert.Len(t, l.Errors(), 1)
+
+	l.Reset(mark)
+	assert.Equal(t, IdentifierToken, l.Token)
+	assert.Equal(t, "alpha", l.String())
+	assert.Equal(t, 0, l.ModeDepth())
+	assert.Empty(t, l.Errors())
+
+	// The mark can be reused until it is committed.
+	require.True(t, l.Advance())
+	require.True(t, l.Advance())
+	assert.Equal(t, "beta", l.String())
+	l.Reset(mark)
+	assert.Equal(t, "alpha", l.String())
+
+	l.Commit(mark)
+	assert.Empty(t, l.pins)
+	// Committing again is harmless.
+	l.Commit(mark)
+}
+
+func TestLexer_Mark_nested(t *testing.T) {
+	l := NewLexer("nested.test", []byte("a b c d"))
+	require.True(t, l.Advance())
+	outer := l.Mark()
+	l.Advance()
+	l.Advance()
+	inner := l.Mark()
+	l.Advance()
+	l.Advance()
+	assert.Equal(t, "c", l.String())
+	l.Reset(inner)
+	assert.Equal(t, "b", l.String())
+	l.Commit(inner)
+	l.Reset(outer)
+	assert.Equal(t, "a", l.String())
+	l.Commit(outer)
+	assert.Empty(t, l.pins)
+}
+
+func TestLexer_Mark_streaming(t *testing.T) {
+	code := strings.Repeat("word ", 50)
+	withChunkSize(8, func() {
+		l := NewReaderLexer("stream.test", strings.NewReader(code))
+		require.True(t, l.Advance())
+		mark := l.Mark()
+		for l.Advance() {
+		}
+		// Everything since the mark is still buffered.
+		assert.Equal(t, 0, l.base)
+		text, ok := l.Slice(0, 4)
+		if assert.True(t, ok) {
+			assert.Equal(t, "word", string(text))
+		}
+
+		l.Reset(mark)
+		assert.Equal(t, "word", l.String())
+		l.Commit(mark)
+		words := 1
+		for l.Advance() {
+			if l.Token == IdentifierToken {
+				words++
+			}
+		}
+		assert.Equal(t, 50, words)
+		assert.Empty(t, l.pins)
+	})
+}
+
+var markArrow = NewTerminal("mark-arrow")
+
+func TestParser_Mark(t *testing.T) {
+	rules := []Rule{{Sequence: []Token{Minus, SymbolToken}, Applies: markArrow}}
+	p := NewParser(NewLexer("mark.test", []byte("a - > b c d")), rules...)
+	mark := p.Mark()
+
+	var identities []string
+	for ; !p.EOF(); p.Next() {
+		identities = append(identities, p.Current().Identity())
+	}
+	want := []string{`"a"`, `mark-arrow ("- >")`, `"b"`, `"c"`, `"d"`}
+	assert.Equal(t, want, identities)
+
+	p.Reset(mark)
+	assert.Equal(t, `"a"`, p.Current().Identity())
+	// The rule that was applied has been undone.
+	assert.Equal(t, `minus-sign ("-")`, p.Peek().Identity())
+	assert.Equal(t, 3, p.Peek().EndOffset)
+
+	identities = nil
+	for ; !p.EOF(); p.Next() {
+		identities = append(identities, p.Current().Identity())
+	}
+	assert.Equal(t, want, identities)
+	p.Commit(mark)
+	// The look-ahead of an in-memory lexer is rewound without pins.
+	assert.Empty(t, p.Lexer.pins)
+}
+
+func TestParser_Mark_streaming(t *testing.T) {
+	withChunkSize(8, func() {
+		p := NewParser(NewReaderLexer("stream.test", strings.NewReader("alpha beta gamma delta")))
+		mark := p.Mark()
+		p.Next()
+		p.Next()
+		p.Reset(mark)
+		assert.Equal(t, "alpha", p.Current().Value)
+		assert.Equal(t, "beta", p.Peek().Value)
+		p.Commit(mark)
+		// Only the parser's own look-ahead remains retained.
+		require.Len(t, p.aheadMarks, 1)
+		assert.Equal(t, []lexerPin{{p.aheadMarks[0].id, p.aheadMarks[0].start}}, p.Lexer.pins)
+	})
+}
+
+func TestParser_Mark_alternatives(t *testing.T) {
+	p := NewParser(NewLexer("alternatives.test", []byte("x = 1 ; y")))
+	p.Next()
+	mark := p.Mark()
+
+	// First alternative: "x ( ...", fails after looking ahead.
+	_, err := p.OptionalSequence(Equals, OpenParen)
+	assert.Error(t, err)
+	p.Reset(mark)
+	assert.Equal(t, Equals, p.Current().Token)
+
+	// Second alternative: "x = 1 ;"
+	matched, err := p.OptionalSequence(Equals, IntegerToken, Semicolon)
+	assert.NoError(t, err)
+	assert.Len(t, matched, 3)
+	p.Commit(mark)
+	assert.Equal(t, `"y"`, p.Current().Identity())
+
+	// Reset to a mark after pushing symbols.
+	pushed := &Symbol{Token: Comma, Value: ","}
+	p.Push([]*Symbol{pushed})
+	mark = p.Mark()
+	p.Next()
+	p.Next()
+	assert.True(t, p.EOF())
+	p.Reset(mark)
+	assert.Same(t, pushed, p.Current())
+	assert.Equal(t, `"y"`, p.Peek().Identity())
+}
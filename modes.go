@@ -0,0 +1,115 @@
+package parsing
+
+// Modes let a Lexer switch lexical rules part way through a file, such as
+// for the expressions embedded in an interpolated string or the body of a
+// heredoc. A mode is simply a Dialect: it has its own character map,
+// keywords, operators, comments and intercepts. Typically an intercept
+// pushes a mode upon seeing the opening sequence, and another intercept
+// registered with the mode pops it upon seeing the close. An EOFToken
+// InterceptRule can close modes that are still open at the end of the source.
+//
+// Keywords, operators and intercepts registered directly with the Lexer
+// belong to its base Dialect and are only used when no mode is pushed.
+
+// PushMode suspends the lexer's current rules and follows those of mode
+// until the corresponding PopMode.
+func (l *Lexer) PushMode(mode *Dialect) {
+	if mode == nil {
+		panic("PushMode requires a Dialect")
+	}
+	l.modes = append(l.modes, mode)
+}
+
+// PopMode returns to the rules that were in effect before the most recent
+// PushMode, and returns the mode that was popped. Panics if there is no mode
+// to pop.
+func (l *Lexer) PopMode() *Dialect {
+	if len(l.modes) == 0 {
+		panic("PopMode called without a matching PushMode")
+	}
+	mode := l.modes[len(l.modes)-1]
+	l.modes = l.modes[:len(l.modes)-1]
+	return mode
+}
+
+// Mode returns the Dialect the lexer is currently following: the most
+// recently pushed mode, or the lexer's Dialect if no modes are pushed.
+func (l *Lexer) Mode() *Dialect {
+	if len(l.modes) > 0 {
+		return l.modes[len(l.modes)-1]
+	}
+	return l.Dialect()
+}
+
+// ModeDepth returns the number of modes currently pushed.
+func (l *Lexer) ModeDepth() int {
+	return len(l.modes)
+}
+
+// currentMode returns the mode the lexer is in, or nil if that is its
+// Dialect, for recording in a Symbol.
+func (l *Lexer) currentMode() *Dialect {
+	if l.inBaseMode() {
+		return nil
+	}
+	return l.Mode()
+}
+
+// inBaseMode returns true when the lexer is following its own Dialect, so
+// that lexer-local keywords, operators and intercepts apply.
+func (l *Lexer) inBaseMode() bool {
+	return len(l.modes) == 0
+}
+
+// AddIntercept registers an intercept with the dialect, see Lexer.AddIntercept.
+// The dialect's intercepts run after any registered with the lexer.
+func (d *Dialect) AddIntercept(token Token, intercept Intercept) {
+	if d.intercepts == nil {
+		d.intercepts = make(InterceptTable)
+	}
+	d.intercepts[token] = append(d.intercepts[token], intercept)
+}
+
+// Mode returns the lexer mode the current symbol was lexed in, see
+// Lexer.PushMode.
+func (p *Parser) Mode() *Dialect {
+	if p.current != nil && p.current.mode != nil {
+		return p.current.mode
+	}
+	return p.Lexer.Dialect()
+}
+
+// PushMode pushes a lexer mode starting with the symbol after Current. Any
+// symbols the parser has already read ahead are discarded and lexed again
+// under the new mode.
+func (p *Parser) PushMode(mode *Dialect) {
+	p.relexAhead(func() { p.Lexer.PushMode(mode) })
+}
+
+// PopMode pops the lexer mode starting with the symbol after Current, see
+// PushMode, and returns the mode that was popped.
+func (p *Parser) PopMode() (mode *Dialect) {
+	p.relexAhead(func() { mode = p.Lexer.PopMode() })
+	return mode
+}
+
+// relexAhead returns the lexer to its state before it read the symbols the
+// parser has read ahead, so that change applies to them, and reads the first
+// of them again. Symbols that were pushed rather than lexed are kept.
+func (p *Parser) relexAhead(change func()) {
+	idx := 0
+	for idx < len(p.ahead) && p.aheadMarks[idx].id == 0 {
+		idx++
+	}
+	if idx == len(p.ahead) {
+		change()
+		return
+	}
+	p.Lexer.Reset(p.aheadMarks[idx])
+	for _, mark := range p.aheadMarks[idx:] {
+		p.Lexer.Commit(mark)
+	}
+	p.ahead, p.aheadMarks = p.ahead[:idx], p.aheadMarks[:idx]
+	change()
+	p.readAhead()
+}
@@ -0,0 +1,171 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	modeA     = NewTerminal("mode-a")
+	modeIf    = NewTerminal("mode-if")
+	modeOpen  = NewTerminal("mode-open")
+	modeClose = NewTerminal("mode-close")
+)
+
+// interpolationDialects returns a base dialect in which "${" enters an
+// expression mode that is left by "}".
+func interpolationDialects() (base, expr *Dialect) {
+	base, expr = NewDialect("base"), NewDialect("expr")
+	expr.AddKeyword("if", modeIf)
+	open := func(l *Lexer) bool {
+		if !l.ForwardOn('{') {
+			return false
+		}
+		l.PushMode(expr)
+		l.Token = modeOpen
+		return true
+	}
+	base.AddIntercept(Dollar, open)
+	expr.AddIntercept(Dollar, open)
+	expr.AddIntercept(CloseBrace, func(l *Lexer) bool {
+		l.PopMode()
+		l.Token = modeClose
+		return true
+	})
+	return base, expr
+}
+
+func TestLexer_PushMode(t *testing.T) {
+	base, expr := interpolationDialects()
+	l := base.NewLexer("modes.test", []byte("a ${a if ${if}} if a {}"))
+	l.AddKeyword("a", modeA)
+	assert.Same(t, base, l.Mode())
+	assert.Equal(t, 0, l.ModeDepth())
+
+	var depths []int
+	var tokens []Token
+	for l.Advance() {
+		if IsSignificant(l.Token) {
+			tokens = append(tokens, l.Token)
+			depths = append(depths, l.ModeDepth())
+		}
+	}
+	assert.Equal(t, []Token{modeA, modeOpen, IdentifierToken, modeIf, modeOpen, modeIf, modeClose, modeClose, IdentifierToken, modeA, OpenBrace, CloseBrace}, tokens)
+	assert.Equal(t, []int{0, 1, 1, 1, 2, 2, 1, 0, 0, 0, 0, 0}, depths)
+	assert.Same(t, base, l.Dialect())
+	assert.Same(t, base, l.Mode())
+
+	l.PushMode(expr)
+	assert.Same(t, base, l.Dialect())
+	assert.Same(t, expr, l.Mode())
+	assert.Same(t, expr, l.PopMode())
+	assert.PanicsWithValue(t, "PopMode called without a matching PushMode", func() { l.PopMode() })
+	assert.PanicsWithValue(t, "PushMode requires a Dialect", func() { l.PushMode(nil) })
+}
+
+func TestLexer_PushMode_characterMap(t *testing.T) {
+	heredoc := NewDialect("heredoc")
+	heredoc.SetQuotes("")
+	heredoc.SetComments()
+	heredoc.AddOperator("EOF", modeClose)
+	l := NewLexer("heredoc.test", []byte("x 'y' // z\n"))
+	require.True(t, l.Advance())
+	l.PushMode(heredoc)
+	tokens, values := lexTokens(l)
+	assert.Equal(t, []Token{SymbolToken, IdentifierToken, SymbolToken, Slash, Slash, IdentifierToken}, tokens)
+	assert.Equal(t, []string{"'", "y", "'", "/", "/", "z"}, values)
+}
+
+func TestParser_Mode(t *testing.T) {
+	base, expr := interpolationDialects()
+	p := NewParser(base.NewLexer("parser.test", []byte("${ if } if")))
+	assert.Equal(t, modeOpen, p.Current().Token)
+	assert.Same(t, base, p.Mode())
+	p.Next()
+	assert.Equal(t, modeIf, p.Current().Token)
+	assert.Same(t, expr, p.Mode())
+	p.Next()
+	assert.Equal(t, modeClose, p.Current().Token)
+	assert.Same(t, expr, p.Mode())
+	p.Next()
+	assert.Equal(t, IdentifierToken, p.Current().Token)
+	assert.Same(t, base, p.Mode())
+}
+
+func TestParser_PushMode(t *testing.T) {
+	raw := NewDialect("raw")
+	raw.SetQuotes("")
+	p := NewParser(NewLexer("parser.test", []byte("a 'b c' d'")))
+	assert.Equal(t, StringToken, p.Peek().Token)
+
+	p.PushMode(raw)
+	assert.Equal(t, SymbolToken, p.Peek().Token)
+	assert.Equal(t, "'", p.Peek().Value)
+	p.Next()
+	assert.Same(t, raw, p.Mode())
+	p.Next()
+	assert.Equal(t, "b", p.Current().Value)
+
+	assert.Same(t, raw, p.PopMode())
+	assert.Equal(t, "c", p.Peek().Value)
+	p.Next()
+	p.Next()
+	assert.Equal(t, StringToken, p.Current().Token)
+	assert.Equal(t, "' d'", p.Current().Value)
+}
+
+func TestParser_PushMode_lexerState(t *testing.T) {
+	raw := NewDialect("raw")
+	raw.SetQuotes("")
+
+	t.Run("errors", func(t *testing.T) {
+		l := NewLexer("errors.test", []byte("a 'b"))
+		l.SetRecovery(true)
+		p := NewParser(l)
+		require.Error(t, l.Err())
+		p.PushMode(raw)
+		assert.Equal(t, "'", p.Peek().Value)
+		assert.NoError(t, l.Err())
+	})
+
+	t.Run("indentation", func(t *testing.T) {
+		l := NewLexer("indent.test", []byte("a:\n  'b'\n"))
+		l.SetIndentation(true)
+		p := NewParser(l)
+		for p.Current().Token != EOLToken {
+			p.Next()
+		}
+		require.Equal(t, IndentToken, p.Peek().Token)
+		p.PushMode(raw)
+		assert.Equal(t, IndentToken, p.Peek().Token)
+		p.Next()
+		p.Next()
+		assert.Equal(t, "'", p.Current().Value)
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		// The rule reads two symbols ahead before failing to match.
+		rules := []Rule{{Sequence: []Token{IdentifierToken, Minus, Minus}, Applies: modeA}}
+		p := NewParser(NewLexer("rules.test", []byte("a - 'b'")), rules...)
+		require.Equal(t, "a", p.Current().Value)
+		p.PushMode(raw)
+		assert.Equal(t, "-", p.Peek().Value)
+		p.Next()
+		assert.Equal(t, "'", p.Peek().Value)
+	})
+
+	t.Run("include", func(t *testing.T) {
+		p := NewParser(includeLexer(map[string]string{"main.cfg": "include \"b.cfg\" 'c'", "b.cfg": "b"}))
+		require.Equal(t, "b", p.Current().Value)
+		require.Equal(t, `"'c'"`, p.Peek().Identity())
+		p.PushMode(raw)
+		assert.Equal(t, "'", p.Peek().Value)
+		assert.Equal(t, "main.cfg:1:17", p.Locate(p.Peek()))
+		p.Next()
+		p.Next()
+		assert.Equal(t, "c", p.Current().Value)
+		assert.Equal(t, 0, p.Lexer.IncludeDepth())
+	})
+}
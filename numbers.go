@@ -0,0 +1,165 @@
+package parsing
+
+import (
+	"sort"
+	"strings"
+)
+
+// NumberSyntax is a set of flags describing which numeric literal forms a
+// Dialect accepts in addition to decimal integers and floats.
+type NumberSyntax uint
+
+const (
+	// HexNumbers accepts hexadecimal integers, e.g. 0x1F.
+	HexNumbers NumberSyntax = 1 << iota
+	// OctalNumbers accepts octal integers, e.g. 0o17.
+	OctalNumbers
+	// BinaryNumbers accepts binary integers, e.g. 0b101.
+	BinaryNumbers
+	// Exponents accepts floats with an exponent, e.g. 1.5e-3 or 1E10.
+	Exponents
+	// DigitSeparators accepts '_' between digits, e.g. 1_000_000.
+	DigitSeparators
+
+	// AllNumbers enables every numeric literal form.
+	AllNumbers = HexNumbers | OctalNumbers | BinaryNumbers | Exponents | DigitSeparators
+)
+
+// radixNames describes each non-decimal radix for error messages.
+var radixNames = map[int]string{16: "hexadecimal", 8: "octal", 2: "binary"}
+
+// SetNumberSyntax determines which numeric literal forms the dialect accepts.
+func (d *Dialect) SetNumberSyntax(syntax NumberSyntax) {
+	d.numbers = syntax
+	d.invalidate()
+}
+
+// AddNumberSuffixes allows numbers to be followed by type suffixes, such as
+// "u", "L" or "f", which become part of the number's value.
+func (d *Dialect) AddNumberSuffixes(suffixes ...string) {
+	d.numberSuffixes = append(d.numberSuffixes, suffixes...)
+	// Longest match first, so that "ul" is preferred over "u".
+	sort.SliceStable(d.numberSuffixes, func(i, j int) bool {
+		return len(d.numberSuffixes[i]) > len(d.numberSuffixes[j])
+	})
+	d.invalidate()
+}
+
+// isDigit returns true if char is a digit in the given radix.
+func (d *Dialect) isDigit(char byte, radix int) bool {
+	switch radix {
+	case 16:
+		return char >= '0' && char <= '9' || char >= 'a' && char <= 'f' || char >= 'A' && char <= 'F'
+	case 8:
+		return char >= '0' && char <= '7'
+	case 2:
+		return char == '0' || char == '1'
+	}
+	return d.charMap[char] == DigitToken
+}
+
+// skipDigits advances over digits of the given radix, and digit separators
+// if the dialect permits them, returning the number of digits consumed.
+func (l *Lexer) skipDigits(dialect *Dialect, radix int) (digits int) {
+	separators := dialect.numbers&DigitSeparators != 0
+	for char, ok := l.Peek(); ok; char, ok = l.Peek() {
+		if dialect.isDigit(char, radix) {
+			digits++
+		} else if separators && char == '_' {
+			previous, _ := l.byteAt(l.End - 1)
+			next, _ := l.byteAt(l.End + 1)
+			if !dialect.isDigit(previous, radix) || !dialect.isDigit(next, radix) {
+				l.fatalAt(l.End, l.End+1, "digit separator '_' must be between digits")
+			}
+		} else {
+			break
+		}
+		l.Skip()
+	}
+	return digits
+}
+
+// symbolizeRadixNumber handles numbers with a 0x, 0o or 0b prefix, returning
+// false if the number doesn't start with an enabled prefix.
+func (l *Lexer) symbolizeRadixNumber(dialect *Dialect) bool {
+	digitStart := l.Start
+	if sign, _ := l.byteAt(l.Start); sign == '+' || sign == '-' {
+		digitStart++
+	}
+	if zero, _ := l.byteAt(digitStart); zero != '0' || l.End > digitStart+1 {
+		return false
+	}
+	prefix, _ := l.byteAt(digitStart + 1)
+	radix := 0
+	switch {
+	case (prefix == 'x' || prefix == 'X') && dialect.numbers&HexNumbers != 0:
+		radix = 16
+	case (prefix == 'o' || prefix == 'O') && dialect.numbers&OctalNumbers != 0:
+		radix = 8
+	case (prefix == 'b' || prefix == 'B') && dialect.numbers&BinaryNumbers != 0:
+		radix = 2
+	default:
+		return false
+	}
+
+	l.End = digitStart + 2
+	l.Token = IntegerToken
+	if l.skipDigits(dialect, radix) == 0 {
+		l.Fatal("%s literal has no digits", radixNames[radix])
+		return true
+	}
+	if next, ok := l.Peek(); ok && dialect.isDigit(next, 10) {
+		l.fatalAt(l.End, l.End+1, "invalid digit '%c' in %s literal", next, radixNames[radix])
+		return true
+	}
+	l.symbolizeNumberSuffix(dialect)
+	return true
+}
+
+// symbolizeExponent consumes an optional exponent, e.g. e10 or E-3, after the
+// digits of a number.
+func (l *Lexer) symbolizeExponent(dialect *Dialect) {
+	if char, ok := l.Peek(); !ok || (char != 'e' && char != 'E') {
+		return
+	}
+	l.Skip()
+	if sign, ok := l.Peek(); ok && (sign == '+' || sign == '-') {
+		l.Skip()
+	}
+	l.Token = FloatToken
+	if l.skipDigits(dialect, 10) == 0 {
+		l.Fatal("exponent has no digits")
+	}
+}
+
+// symbolizeNumberSuffix consumes one of the dialect's number suffixes, if
+// one follows and isn't the start of a longer identifier.
+func (l *Lexer) symbolizeNumberSuffix(dialect *Dialect) {
+	for _, suffix := range dialect.numberSuffixes {
+		if !l.hasPrefix(l.End, suffix) {
+			continue
+		}
+		if next, ok := l.byteAt(l.End + len(suffix)); ok && dialect.IsIdentifierContinuation(next) {
+			continue
+		}
+		l.End += len(suffix)
+		return
+	}
+}
+
+// Radix returns the base in which a numeric Symbol is written: 16, 8 or 2
+// for values with a 0x, 0o or 0b prefix respectively, otherwise 10.
+func (s *Symbol) Radix() int {
+	value := strings.TrimLeft(s.Value, "+-")
+	if len(value) > 1 && value[0] == '0' {
+		switch value[1] {
+		case 'x', 'X':
+			return 16
+		case 'o', 'O':
+			return 8
+		case 'b', 'B':
+			return 2
+		}
+	}
+	return 10
+}
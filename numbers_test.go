@@ -0,0 +1,119 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialect_SetNumberSyntax(t *testing.T) {
+	d := NewDialect("numbers")
+	d.SetNumberSyntax(AllNumbers)
+	d.AddNumberSuffixes("u", "ul", "f")
+	assert.Equal(t, []string{"ul", "u", "f"}, d.numberSuffixes)
+
+	tests := []struct {
+		code  string
+		token Token
+		want  string
+		radix int
+	}{
+		{"123", IntegerToken, "123", 10},
+		{"1.5", FloatToken, "1.5", 10},
+		{"0x1F", IntegerToken, "0x1F", 16},
+		{"0XdeadBEEF", IntegerToken, "0XdeadBEEF", 16},
+		{"0o17", IntegerToken, "0o17", 8},
+		{"0b101", IntegerToken, "0b101", 2},
+		{"-0x10", IntegerToken, "-0x10", 16},
+		{"1e10", FloatToken, "1e10", 10},
+		{"1.5e-3", FloatToken, "1.5e-3", 10},
+		{"2E+8", FloatToken, "2E+8", 10},
+		{".5e3", FloatToken, ".5e3", 10},
+		{"1_000_000", IntegerToken, "1_000_000", 10},
+		{"0x_ff", ErrorToken, "0x_ff", 16},
+		{"0xff_ff", IntegerToken, "0xff_ff", 16},
+		{"3.141_592", FloatToken, "3.141_592", 10},
+		{"10u", IntegerToken, "10u", 10},
+		{"10ul", IntegerToken, "10ul", 10},
+		{"1.5f", FloatToken, "1.5f", 10},
+		{"0x1Fu", IntegerToken, "0x1Fu", 16},
+		{"10units", IntegerToken, "10", 10},
+		{"0", IntegerToken, "0", 10},
+		{"0.5", FloatToken, "0.5", 10},
+		{"007", IntegerToken, "007", 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			l := d.NewLexer("numbers.test", []byte(tt.code))
+			l.SetRecovery(true)
+			if assert.True(t, l.Advance()) && assert.Equal(t, tt.token, l.Token) {
+				assert.Equal(t, tt.want, l.String())
+				symbol := &Symbol{Token: l.Token, Value: l.String()}
+				assert.Equal(t, tt.radix, symbol.Radix())
+			}
+		})
+	}
+}
+
+func TestDialect_SetNumberSyntax_disabled(t *testing.T) {
+	tests := []struct {
+		code   string
+		values []string
+	}{
+		{"0x1F", []string{"0", "x1F"}},
+		{"1e10", []string{"1", "e10"}},
+		{"1_000", []string{"1", "_000"}},
+		{"0b1", []string{"0", "b1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			_, values := lexTokens(NewLexer("legacy.test", []byte(tt.code)))
+			assert.Equal(t, tt.values, values)
+		})
+	}
+
+	t.Run("selective", func(t *testing.T) {
+		d := NewDialect("hex only")
+		d.SetNumberSyntax(HexNumbers)
+		_, values := lexTokens(d.NewLexer("selective.test", []byte("0x1F 0b1 1e3")))
+		assert.Equal(t, []string{"0x1F", "0", "b1", "1", "e3"}, values)
+	})
+}
+
+func TestDialect_SetNumberSyntax_errors(t *testing.T) {
+	d := NewDialect("numbers")
+	d.SetNumberSyntax(AllNumbers)
+	tests := []struct {
+		code, err string
+	}{
+		{"0x", "errors.test:1:1-1:3: error: hexadecimal literal has no digits"},
+		{"0o ", "errors.test:1:1-1:3: error: octal literal has no digits"},
+		{"0bz", "errors.test:1:1-1:3: error: binary literal has no digits"},
+		{"0b102", "errors.test:1:5: error: invalid digit '2' in binary literal"},
+		{"0o19", "errors.test:1:4: error: invalid digit '9' in octal literal"},
+		{"1e", "errors.test:1:1-1:3: error: exponent has no digits"},
+		{"1.5e+", "errors.test:1:1-1:6: error: exponent has no digits"},
+		{"1_", "errors.test:1:2: error: digit separator '_' must be between digits"},
+		{"1__0", "errors.test:1:2: error: digit separator '_' must be between digits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			l := d.NewLexer("errors.test", []byte(tt.code))
+			assert.PanicsWithError(t, tt.err, func() { l.Advance() })
+		})
+	}
+}
+
+func TestSymbol_Radix(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 10}, {"0", 10}, {"12", 10}, {"0x", 16}, {"+0X1", 16},
+		{"-0o7", 8}, {"0O7", 8}, {"0b1", 2}, {"0B1", 2}, {"0.5", 10},
+	}
+	for _, tt := range tests {
+		symbol := &Symbol{Token: IntegerToken, Value: tt.value}
+		assert.Equal(t, tt.want, symbol.Radix(), tt.value)
+	}
+}
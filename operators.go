@@ -0,0 +1,93 @@
+package parsing
+
+import (
+	"fmt"
+	"sort"
+)
+
+// operator associates the spelling of a multi-character operator, such as
+// "->" or "<<=", with the Terminal that represents it.
+type operator struct {
+	spelling string
+	token    Token
+}
+
+// addOperator returns operators with spelling registered as token, keeping
+// the longest spellings first so that matches are maximal munch.
+func addOperator(operators []operator, spelling string, token Token) []operator {
+	if spelling == "" {
+		panic("operators must have a spelling")
+	}
+	if !token.IsTerminal() {
+		panic(fmt.Sprintf("operators must be represented by Terminals: %q is a Token", token.String()))
+	}
+	for idx := range operators {
+		if operators[idx].spelling == spelling {
+			operators[idx].token = token
+			return operators
+		}
+	}
+	operators = append(operators, operator{spelling, token})
+	sort.SliceStable(operators, func(i, j int) bool {
+		return len(operators[i].spelling) > len(operators[j].spelling)
+	})
+	return operators
+}
+
+// AddOperator registers a Terminal for a sequence of punctuation, e.g.
+// AddOperator("->", Arrow). The lexer matches the longest registered operator
+// at the start of each token, so with "<", "<=" and "<<=" registered, "<<="
+// is a single symbol. Operators take precedence over the character map,
+// including the sign of a number: if "-" is registered, "-1" will lex as "-"
+// followed by the integer 1.
+func (d *Dialect) AddOperator(spelling string, token Token) {
+	d.operators = addOperator(d.operators, spelling, token)
+	d.operatorStarts[spelling[0]] = true
+	d.invalidate()
+}
+
+// Operator returns the Terminal registered for an operator, if any.
+func (d *Dialect) Operator(spelling string) (Token, bool) {
+	for _, op := range d.operators {
+		if op.spelling == spelling {
+			return op.token, true
+		}
+	}
+	return InvalidToken, false
+}
+
+// AddOperator registers an operator Terminal with the lexer, see
+// Dialect.AddOperator. Operators registered with the lexer take precedence
+// over those of its Dialect of the same length.
+func (l *Lexer) AddOperator(spelling string, token Token) {
+	l.operators = addOperator(l.operators, spelling, token)
+}
+
+// symbolizeOperator attempts to match the longest operator registered with the
+// lexer or its dialect at the start of the current token. A nil dialect only
+// matches the lexer's operators.
+func (l *Lexer) symbolizeOperator(dialect *Dialect) bool {
+	best := -1
+	var local, dialectOperators []operator
+	if l.inBaseMode() {
+		local = l.operators
+	}
+	if dialect != nil {
+		dialectOperators = dialect.operators
+	}
+	for _, operators := range [][]operator{local, dialectOperators} {
+		for _, op := range operators {
+			if len(op.spelling) > best && l.hasPrefix(l.Start, op.spelling) {
+				best = len(op.spelling)
+				l.Token = op.token
+				// operators are sorted longest first.
+				break
+			}
+		}
+	}
+	if best < 0 {
+		return false
+	}
+	l.End = l.Start + best
+	return true
+}
@@ -0,0 +1,125 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	opArrow       = NewTerminal("arrow")
+	opLess        = NewTerminal("less-than")
+	opLessEqual   = NewTerminal("less-or-equal")
+	opShiftLeft   = NewTerminal("shift-left")
+	opShiftAssign = NewTerminal("shift-left-assign")
+	opEllipsis    = NewTerminal("ellipsis")
+	opScope       = NewTerminal("scope")
+	opMinus       = NewTerminal("minus")
+)
+
+// operatorDialect returns a dialect with a C-like set of operators.
+func operatorDialect() *Dialect {
+	d := NewDialect("operators")
+	d.AddOperator("<", opLess)
+	d.AddOperator("<<=", opShiftAssign)
+	d.AddOperator("->", opArrow)
+	d.AddOperator("<=", opLessEqual)
+	d.AddOperator("<<", opShiftLeft)
+	d.AddOperator("...", opEllipsis)
+	d.AddOperator("::", opScope)
+	return d
+}
+
+func TestDialect_AddOperator(t *testing.T) {
+	d := operatorDialect()
+	assert.Equal(t, []string{"<<=", "...", "->", "<=", "<<", "::", "<"}, func() (spellings []string) {
+		for _, op := range d.operators {
+			spellings = append(spellings, op.spelling)
+		}
+		return
+	}())
+	assert.True(t, d.operatorStarts['<'])
+	assert.False(t, d.operatorStarts['>'])
+
+	token, ok := d.Operator("->")
+	assert.True(t, ok)
+	assert.Equal(t, opArrow, token)
+	_, ok = d.Operator("-")
+	assert.False(t, ok)
+
+	// Re-registering replaces the terminal.
+	d.AddOperator("->", opScope)
+	token, _ = d.Operator("->")
+	assert.Equal(t, opScope, token)
+	assert.Len(t, d.operators, 7)
+
+	assert.Panics(t, func() { d.AddOperator("", opArrow) })
+	assert.Panics(t, func() { d.AddOperator("=>", IdentifierToken) })
+}
+
+func TestLexer_operators(t *testing.T) {
+	tests := []struct {
+		code   string
+		tokens []Token
+		values []string
+	}{
+		{"a<b", []Token{IdentifierToken, opLess, IdentifierToken}, []string{"a", "<", "b"}},
+		{"a<=b", []Token{IdentifierToken, opLessEqual, IdentifierToken}, []string{"a", "<=", "b"}},
+		{"a<<=b", []Token{IdentifierToken, opShiftAssign, IdentifierToken}, []string{"a", "<<=", "b"}},
+		{"a<<<b", []Token{IdentifierToken, opShiftLeft, opLess, IdentifierToken}, []string{"a", "<<", "<", "b"}},
+		{"p->q", []Token{IdentifierToken, opArrow, IdentifierToken}, []string{"p", "->", "q"}},
+		{"a::b", []Token{IdentifierToken, opScope, IdentifierToken}, []string{"a", "::", "b"}},
+		{"f(...)", []Token{IdentifierToken, OpenParen, opEllipsis, CloseParen}, []string{"f", "(", "...", ")"}},
+		{"a:b", []Token{IdentifierToken, Colon, IdentifierToken}, []string{"a", ":", "b"}},
+		{"..5", []Token{Period, FloatToken}, []string{".", ".5"}},
+		{"-1 -> 2", []Token{IntegerToken, opArrow, IntegerToken}, []string{"-1", "->", "2"}},
+		{"a </* c */ b", []Token{IdentifierToken, opLess, IdentifierToken}, []string{"a", "<", "b"}},
+	}
+	d := operatorDialect()
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			tokens, values := lexTokens(d.NewLexer("operators.test", []byte(tt.code)))
+			assert.Equal(t, tt.tokens, tokens)
+			assert.Equal(t, tt.values, values)
+		})
+	}
+
+	t.Run("signs", func(t *testing.T) {
+		d := NewDialect("signs")
+		d.AddOperator("-", opMinus)
+		tokens, values := lexTokens(d.NewLexer("signs.test", []byte("-1")))
+		assert.Equal(t, []Token{opMinus, IntegerToken}, tokens)
+		assert.Equal(t, []string{"-", "1"}, values)
+	})
+}
+
+func TestLexer_AddOperator(t *testing.T) {
+	d := operatorDialect()
+	l := d.NewLexer("local.test", []byte("a<-b <<= c"))
+	l.AddOperator("<-", opArrow)
+	l.AddOperator("<<=", opMinus)
+	tokens, values := lexTokens(l)
+	assert.Equal(t, []Token{IdentifierToken, opArrow, IdentifierToken, opMinus, IdentifierToken}, tokens)
+	assert.Equal(t, []string{"a", "<-", "b", "<<=", "c"}, values)
+
+	// Other lexers of the dialect are unaffected, and the default dialect has no operators.
+	tokens, _ = lexTokens(d.NewLexer("other.test", []byte("a<-b")))
+	assert.Equal(t, []Token{IdentifierToken, opLess, Minus, IdentifierToken}, tokens)
+
+	l = NewLexer("default.test", []byte("a->b"))
+	l.AddOperator("->", opArrow)
+	tokens, _ = lexTokens(l)
+	assert.Equal(t, []Token{IdentifierToken, opArrow, IdentifierToken}, tokens)
+	assert.Nil(t, DefaultDialect.operators)
+}
+
+func TestParser_operators(t *testing.T) {
+	p := NewParser(operatorDialect().NewLexer("parser.test", []byte("a -> <= b")))
+	p.Next()
+	assert.Equal(t, `arrow ("->")`, p.Current().Identity())
+	p.Next()
+	_, err := p.Expecting(opArrow, IdentifierToken)
+	if assert.Error(t, err) {
+		assert.Equal(t, `parser.test:1:6: syntax error: expected either arrow or IDENTIFIER, got: less-or-equal ("<=")`, err.Error())
+	}
+}
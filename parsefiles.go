@@ -33,9 +33,9 @@ func ParseFiles(extension string, parseFn func(string), pathlist []string) {
 
 			for filepath := range workQueue {
 				if *stats.Verbose > 1 {
-					stats.Time(filepath, false, func() { parseFn(filepath) })
+					stats.Time(filepath, false, func() { parseFile(parseFn, filepath) })
 				} else {
-					parseFn(filepath)
+					parseFile(parseFn, filepath)
 				}
 			}
 		}()
@@ -44,3 +44,19 @@ func ParseFiles(extension string, parseFn func(string), pathlist []string) {
 	// Wait for the work to complete.
 	workers.Wait()
 }
+
+// parseFile runs parseFn on a single file. A *LexError panic is reported via
+// RaiseHandler rather than allowed to terminate the process, so that one bad
+// file does not prevent the others being parsed.
+func parseFile(parseFn func(string), filepath string) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*LexError)
+			if !ok {
+				panic(r)
+			}
+			RaiseHandler(err)
+		}
+	}()
+	parseFn(filepath)
+}
@@ -3,10 +3,20 @@ package parsing
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/kfsone/parsing/lib/stats"
 )
 
+// SymbolSource supplies a Parser with Symbols. By default, a Parser reads
+// directly from its Lexer, but it can be given a source that transforms the
+// lexer's output, such as a Preprocessor.
+type SymbolSource interface {
+	// NextSymbol returns the next significant Symbol, with the trivia that
+	// precedes it. At end-of-file, the Symbol is an EOFToken.
+	NextSymbol() (symbol Symbol, trivia []Symbol)
+}
+
 type Rule struct {
 	Sequence []Token
 	Applies  Token
@@ -15,9 +25,14 @@ type Rule struct {
 // Parser is a core implementation of a lexing-based parser implementation.
 type Parser struct {
 	Lexer   *Lexer
+	source  SymbolSource
 	current *Symbol
 	ahead   []*Symbol
 	rules   []Rule
+	slab    symbolSlab // storage for symbols
+	// aheadMarks are the states of the lexer before it read each symbol in
+	// ahead, for relexAhead, or zero for symbols that weren't lexed.
+	aheadMarks []LexerMark
 
 	Tracing        bool
 	VerboseTracing bool
@@ -26,9 +41,15 @@ type Parser struct {
 // NewParser will construct a new parser instance and read-ahead the
 // first two symbols.
 func NewParser(l *Lexer, rules ...Rule) *Parser {
-	// Create an ahead buffer with 2 nil entries for the first two reads.
+	return newParser(l, nil, rules)
+}
+
+// newParser constructs a parser that reads symbols from source, if not nil,
+// or directly from l.
+func newParser(l *Lexer, source SymbolSource, rules []Rule) *Parser {
 	p := &Parser{
 		Lexer:          l,
+		source:         source,
 		current:        nil,
 		ahead:          make([]*Symbol, 0, 64),
 		rules:          rules,
@@ -69,9 +90,23 @@ func (p *Parser) EOF() bool {
 }
 
 // Locate returns a string describing the filename, line number and character
-// of a symbol.
+// of a symbol, which may be in an included file. If the lexer has a FileSet,
+// the symbol may be from any of its files.
 func (p *Parser) Locate(symbol *Symbol) string {
-	return fmt.Sprintf("%s:%d:%d", p.Lexer.Filename(), p.Lexer.LineNo(symbol.StartOffset), p.Lexer.CharNo(symbol.StartOffset))
+	if fileSet := p.Lexer.FileSet(); fileSet != nil && symbol.Pos.IsValid() {
+		return fileSet.Position(symbol.Pos).String()
+	}
+	return p.Lexer.locate(symbol.origin, symbol.StartOffset)
+}
+
+// Unquote returns the decoded value of a string Symbol, see Symbol.Unquote,
+// with any error describing the location of the invalid escape sequence.
+func (p *Parser) Unquote(symbol *Symbol) (string, error) {
+	value, err := symbol.Unquote()
+	if escapeErr, ok := err.(*EscapeError); ok {
+		return "", fmt.Errorf("%s: %s", p.Lexer.locate(symbol.origin, escapeErr.Offset), escapeErr.Message)
+	}
+	return value, err
 }
 
 // Push injects Symbols into the Symbol at the current position,
@@ -82,18 +117,49 @@ func (p *Parser) Push(symbols []*Symbol) {
 	}
 	p.current, symbols = symbols[0], append(symbols[1:], p.current)
 	p.ahead = append(symbols, p.ahead...)
+	p.aheadMarks = append(make([]LexerMark, len(symbols)), p.aheadMarks...)
 }
 
 // readAhead gets the next symbol from the lexer and adds it to the end of the
-// 'ahead' buffer.
+// 'ahead' buffer. Trivia is attached to the new symbol and the one before it.
 func (p *Parser) readAhead() {
-	for {
-		p.Lexer.Advance()
-		if IsSignificant(p.Lexer.Token) {
-			break
+	if len(p.rules) > 0 && p.current != nil {
+		// Rules re-read the source text of the symbols they combine.
+		p.Lexer.Retain(p.current.StartOffset)
+	}
+	mark := p.Lexer.plainMark()
+	if !p.Lexer.stateless() {
+		mark = p.Lexer.Mark()
+	}
+	symbol := &p.slab.alloc(1)[0]
+	var trivia []Symbol
+	if p.source != nil {
+		*symbol, trivia = p.source.NextSymbol()
+	} else {
+		*symbol, trivia = p.Lexer.NextSymbol()
+	}
+	if len(trivia) > 0 {
+		previous := p.current
+		if len(p.ahead) > 0 {
+			previous = p.ahead[len(p.ahead)-1]
+		}
+		if previous != nil {
+			previous.Trailing, trivia = splitTrivia(trivia)
 		}
+		symbol.Leading = trivia
+	}
+	p.ahead = append(p.ahead, symbol)
+	p.aheadMarks = append(p.aheadMarks, mark)
+}
+
+// dropAhead removes the first n symbols from the read-ahead buffer.
+func (p *Parser) dropAhead(n int) {
+	for _, mark := range p.aheadMarks[:n] {
+		p.Lexer.Commit(mark)
 	}
-	p.ahead = append(p.ahead, &Symbol{p.Lexer.Token, p.Lexer.String(), p.Lexer.Start, p.Lexer.End})
+	// Shift rather than reslice, so that the buffers keep their capacity.
+	p.ahead = append(p.ahead[:0], p.ahead[n:]...)
+	p.aheadMarks = append(p.aheadMarks[:0], p.aheadMarks[n:]...)
 }
 
 func (r Rule) apply(p *Parser) bool {
@@ -114,9 +180,12 @@ func (r Rule) apply(p *Parser) bool {
 	aheadCount := len(r.Sequence) - 1
 	p.current.EndOffset = p.ahead[aheadCount-1].EndOffset
 	// readjust the window
-	p.current.Value = string(p.Lexer.code[p.current.StartOffset:p.current.EndOffset])
+	value, _ := p.Lexer.Slice(p.current.StartOffset, p.current.EndOffset)
+	p.current.Value = string(value)
 	p.readAhead()
-	p.ahead = p.ahead[aheadCount:]
+	// Trivia between the combined symbols is now part of the value.
+	p.current.Trailing = p.ahead[aheadCount-1].Trailing
+	p.dropAhead(aheadCount)
 
 	return true
 }
@@ -130,7 +199,8 @@ func (p *Parser) applyRules() {
 }
 
 func (p *Parser) advance() Token {
-	p.current, p.ahead = p.ahead[0], p.ahead[1:]
+	p.current = p.ahead[0]
+	p.dropAhead(1)
 	p.readAhead()
 	p.applyRules()
 	return p.current.Token
@@ -148,6 +218,7 @@ func (p *Parser) Next() (token Token) {
 
 // Expecting will return current symbol if it corresponds to one of the given
 // tokens, or it will return a user-friendly error describing the expectation.
+// Contextual keywords are accepted, see AcceptKeyword.
 // Panics on an unexpected end-of-file.
 func (p *Parser) Expecting(tokens ...Token) (*Symbol, error) {
 	if len(tokens) == 0 {
@@ -158,6 +229,12 @@ func (p *Parser) Expecting(tokens ...Token) (*Symbol, error) {
 			return p.current, nil
 		}
 	}
+	// Otherwise, the current symbol may be a contextual keyword.
+	for _, token := range tokens {
+		if token.IsTerminal() && p.AcceptKeyword(token) {
+			return p.current, nil
+		}
+	}
 
 	// If this is because we reached EOF, make it fatal.
 	if p.EOF() {
@@ -212,12 +289,18 @@ func (p *Parser) OptionalSequence(tokens ...Token) (seen []*Symbol, err error) {
 	return matched, nil
 }
 
-// Errorf formats an error based on a Symbol's location.
+// Errorf formats an error based on a Symbol's location, followed by an
+// "included from" line for each include through which it was reached.
 func (p *Parser) Errorf(symbol *Symbol, msg string, args ...interface{}) error {
-	return fmt.Errorf("%s: %s: %s",
+	var includes strings.Builder
+	for _, include := range p.Lexer.includedFrom(symbol.origin) {
+		includes.WriteString("\n\tincluded from " + include)
+	}
+	return fmt.Errorf("%s: %s: %s%s",
 		p.Locate(symbol),
 		fmt.Sprintf(msg, args...),
-		symbol.Identity())
+		symbol.Identity(),
+		includes.String())
 }
 
 // SyntaxErrorf formats a syntax error based on a symbol.
@@ -225,7 +308,14 @@ func (p *Parser) SyntaxErrorf(symbol *Symbol, msg string, args ...interface{}) e
 	return p.Errorf(symbol, "syntax error: expected %s, got", fmt.Sprintf(msg, args...))
 }
 
+// DuplicateErrorf formats an error for a symbol that repeats an earlier one,
+// followed by the location of the original, which originalParser parsed. If
+// originalParser is nil, the original is located by this parser, which can
+// locate symbols from any file of its lexer's FileSet, see Lexer.SetFileSet.
 func (p *Parser) DuplicateErrorf(duplicate *Symbol, original *Symbol, originalParser *Parser, msg string, args ...interface{}) error {
+	if originalParser == nil {
+		originalParser = p
+	}
 	err := p.Errorf(duplicate, msg, args...)
 	return fmt.Errorf("%w\n%s: \\-> previous occurrence of %q is here", err, originalParser.Locate(original), duplicate)
 }
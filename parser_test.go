@@ -1,6 +1,7 @@
 package parsing
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -80,7 +81,10 @@ func Test_NewParser(t *testing.T) {
 	require.NotNil(t, p)
 
 	// Should have skipped the comment/whitespace.
-	expectCurrent := Symbol{Token: Period, Value: ".", StartOffset: 3, EndOffset: 4}
+	expectCurrent := Symbol{Token: Period, Value: ".", StartOffset: 3, EndOffset: 4, Leading: []Symbol{
+		{Token: CommentToken, Value: "//", StartOffset: 0, EndOffset: 2},
+		{Token: NewlineToken, Value: "\n", StartOffset: 2, EndOffset: 3},
+	}}
 	expectAhead := Symbol{Token: StringToken, Value: "'hello'", StartOffset: 4, EndOffset: 11}
 
 	expect := Parser{
@@ -90,6 +94,8 @@ func Test_NewParser(t *testing.T) {
 		rules:          nil,
 		Tracing:        false,
 		VerboseTracing: false,
+		slab:           p.slab,
+		aheadMarks:     p.aheadMarks,
 	}
 	assert.EqualValues(t, expect, *p)
 }
@@ -300,8 +306,42 @@ func TestParser_DuplicateErrorf(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("file set", func(t *testing.T) {
+		set := NewFileSet()
+		l1 := NewLexer("tests/duplicateerrorf.test", []byte("monkey see"))
+		l1.SetFileSet(set)
+		l2 := NewLexer("differentfile.test", []byte("\n  monkey do"))
+		l2.SetFileSet(set)
+		first := NewParser(l1).Current()
+		p2 := NewParser(l2)
+		err := p2.DuplicateErrorf(p2.Current(), first, nil, "another %s", "noun")
+		if assert.NotNil(t, err) {
+			line1 := "differentfile.test:2:3: another noun: \"monkey\""
+			line2 := "tests/duplicateerrorf.test:1:1: \\-> previous occurrence of \"monkey\" is here"
+			assert.Equal(t, line1+"\n"+line2, err.Error())
+		}
+	})
 }
 
 func TestParser_Raise(t *testing.T) {
 
 }
+
+// benchmarkSource is a large source of keywords, identifiers, numbers,
+// strings and comments for the parser and tokenizer benchmarks.
+var benchmarkSource = []byte(strings.Repeat("func alpha(beta, gamma) { return beta + gamma * 12 } // sum\nvar delta = \"epsilon\"\n", 500))
+
+func BenchmarkParser_Next(b *testing.B) {
+	keywordFunc, keywordReturn, keywordVar := NewTerminal("bench-func"), NewTerminal("bench-return"), NewTerminal("bench-var")
+	b.SetBytes(int64(len(benchmarkSource)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := NewLexer("bench", benchmarkSource)
+		l.AddKeyword("func", keywordFunc)
+		l.AddKeyword("return", keywordReturn)
+		l.AddKeyword("var", keywordVar)
+		for p := NewParser(l); !p.EOF(); p.Next() {
+		}
+	}
+}
@@ -0,0 +1,581 @@
+package parsing
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Patterns declare the shape of a token as a regular expression, using the
+// syntax of the regexp package, rather than hand-writing an Intercept.
+//
+// Once a dialect has patterns, its lexers use a DFA compiled from the
+// patterns, the dialect's keywords and operators and the base classes of its
+// character map to decide each token. The longest match wins; when matches
+// are the same length, keywords take priority over operators, operators over
+// patterns, patterns in the order they were added and finally the base
+// classes. When a base class wins, the token is lexed as it would have been
+// without patterns, e.g. a string or number.
+//
+// Keywords and operators added to a Lexer rather than its Dialect only apply
+// to tokens that are left to the base classes.
+
+// pattern is a token declared with AddPattern.
+type pattern struct {
+	token  Token
+	source string
+	regexp *syntax.Regexp
+}
+
+// AddPattern declares that text matching the regular expression pattern is
+// a token, e.g. AddPattern(DurationToken, "[0-9]+(ms|s|m|h)"). Patterns are
+// matched against the text at the start of each token, so anchors and word
+// boundaries are not supported, nor are patterns that match empty text.
+func (d *Dialect) AddPattern(token Token, source string) error {
+	re, err := syntax.Parse(source, syntax.Perl)
+	if err != nil {
+		return fmt.Errorf("pattern %q: %w", source, err)
+	}
+	re = re.Simplify()
+	// Compile on its own to validate it, then invalidate the dialect's DFA.
+	var n nfa
+	f, err := n.compile(re)
+	if err != nil {
+		return fmt.Errorf("pattern %q: %w", source, err)
+	}
+	n.states[f.end].accept = 0
+	if n.closure([]int{f.start}).accept(n.states) >= 0 {
+		return fmt.Errorf("pattern %q matches empty text", source)
+	}
+	d.patterns = append(d.patterns, pattern{token, source, re})
+	d.invalidate()
+	return nil
+}
+
+// invalidate discards the dialect's compiled DFA after its rules change.
+func (d *Dialect) invalidate() {
+	if d.patterns != nil {
+		d.automaton.Store((*dfa)(nil))
+	}
+}
+
+// dfa returns the dialect's compiled DFA, compiling it if necessary, or nil
+// if the dialect has no patterns.
+func (d *Dialect) dfa() *dfa {
+	if d.patterns == nil {
+		return nil
+	}
+	if compiled, _ := d.automaton.Load().(*dfa); compiled != nil {
+		return compiled
+	}
+	compiled := d.compile()
+	d.automaton.Store(compiled)
+	return compiled
+}
+
+// dfaRule is an outcome of a DFA match, in order of priority.
+type dfaRule struct {
+	token Token
+	base  bool // leave the token to the base classes
+}
+
+// dfa is a table-driven deterministic automaton over bytes.
+type dfa struct {
+	next   []int32 // next[state*256+char], or -1
+	accept []int32 // the highest priority rule each state accepts, or -1
+	rules  []dfaRule
+}
+
+// match returns the longest match at pos, and the rule that accepted it.
+func (a *dfa) match(l *Lexer, pos int) (rule *dfaRule, length int) {
+	state := int32(0)
+	// Run through the buffered source, reading more only when it runs out.
+	for end := pos; ; {
+		if _, ok := l.byteAt(end); !ok {
+			return rule, length
+		}
+		for _, char := range l.code[end-l.base:] {
+			end++
+			if state = a.next[int(state)<<8|int(char)]; state < 0 {
+				return rule, length
+			}
+			if accept := a.accept[state]; accept >= 0 {
+				rule, length = &a.rules[accept], end-pos
+			}
+		}
+	}
+}
+
+// symbolizePattern attempts to match the dialect's DFA at the start of the
+// current token. Returns false if the token is left to the base classes.
+func (l *Lexer) symbolizePattern(automaton *dfa) bool {
+	rule, length := automaton.match(l, l.Start)
+	if rule == nil || rule.base {
+		return false
+	}
+	l.Token, l.End = rule.token, l.Start+length
+	return true
+}
+
+// compile builds a DFA from the dialect's keywords, operators, patterns and
+// character classes.
+func (d *Dialect) compile() *dfa {
+	var n nfa
+	var rules []dfaRule
+	var starts []int
+	add := func(f fragment, rule dfaRule) {
+		n.states[f.end].accept = len(rules)
+		rules = append(rules, rule)
+		starts = append(starts, f.start)
+	}
+
+	// Keywords, sorted for a deterministic result.
+	keywords := make([]string, 0, len(d.keywords))
+	for keyword := range d.keywords {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		add(n.literal(keyword), dfaRule{token: d.keywords[keyword]})
+	}
+	keywords = keywords[:0]
+	for keyword := range d.flaggedKeywords {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		switch flagged := d.flaggedKeywords[keyword]; {
+		case flagged.flags&Contextual != 0:
+			// Left to the parser.
+		case flagged.flags&CaseInsensitive != 0:
+			add(n.foldedLiteral(keyword), dfaRule{token: flagged.token})
+		default:
+			add(n.literal(keyword), dfaRule{token: flagged.token})
+		}
+	}
+	for _, op := range d.operators {
+		add(n.literal(op.spelling), dfaRule{token: op.token})
+	}
+	for _, p := range d.patterns {
+		f, _ := n.compile(p.regexp) // validated by AddPattern
+		add(f, dfaRule{token: p.token})
+	}
+	for _, f := range d.baseFragments(&n) {
+		add(f, dfaRule{base: true})
+	}
+
+	// Subset construction, with each DFA state keyed by its set of NFA states.
+	automaton := &dfa{rules: rules}
+	ids := map[string]int32{}
+	var queue []nfaSet
+	state := func(set nfaSet) int32 {
+		key := set.key()
+		if id, ok := ids[key]; ok {
+			return id
+		}
+		id := int32(len(queue))
+		ids[key] = id
+		queue = append(queue, set)
+		automaton.accept = append(automaton.accept, int32(set.accept(n.states)))
+		automaton.next = append(automaton.next, make([]int32, 256)...)
+		return id
+	}
+	state(n.closure(starts))
+	for id := 0; id < len(queue); id++ {
+		for char := 0; char < 256; char++ {
+			var targets []int
+			for _, from := range queue[id] {
+				for _, edge := range n.states[from].edges {
+					if byte(char) >= edge.lo && byte(char) <= edge.hi {
+						targets = append(targets, edge.to)
+					}
+				}
+			}
+			next := int32(-1)
+			if len(targets) > 0 {
+				next = state(n.closure(targets))
+			}
+			automaton.next[id*256+char] = next
+		}
+	}
+	return automaton
+}
+
+// baseFragments returns NFA fragments approximating each base class of the
+// dialect's character map: identifiers, numbers, runs of whitespace and
+// newlines, and any other single character.
+func (d *Dialect) baseFragments(n *nfa) []fragment {
+	var alpha, ident, digits, signs, space, newline, period, single byteSet
+	for char := 0; char < 256; char++ {
+		switch d.charMap[char] {
+		case AlphaToken, Underscore:
+			alpha[char] = true
+		case DigitToken:
+			digits[char] = true
+		case Plus, Minus:
+			signs[char] = true
+		case WhitespaceToken:
+			space[char] = true
+		case NewlineToken:
+			newline[char] = true
+		case Period:
+			period[char] = true
+		}
+		ident[char] = d.IsIdentifierContinuation(byte(char))
+		single[char] = d.charMap[char] != InvalidToken
+	}
+	if d.numbers&DigitSeparators != 0 {
+		digits['_'] = true
+	}
+	// A sign that is also an operator is lexed as the operator, as it is
+	// without patterns.
+	for _, op := range d.operators {
+		if len(op.spelling) == 1 {
+			signs[op.spelling[0]] = false
+		}
+	}
+
+	// [sign] (digits [. digits*] | . digits+) [exponent] [suffix]
+	number := n.concat(
+		n.quest(n.set(signs)),
+		n.alternate(
+			n.concat(n.plus(n.set(digits)), n.quest(n.concat(n.set(period), n.star(n.set(digits))))),
+			n.concat(n.set(period), n.plus(n.set(digits)))))
+	if d.numbers&Exponents != 0 {
+		number = n.concat(number, n.quest(n.concat(
+			n.set(byteSetOf("eE")), n.quest(n.set(byteSetOf("+-"))), n.plus(n.set(digits)))))
+	}
+	if len(d.numberSuffixes) > 0 {
+		suffixes := make([]fragment, len(d.numberSuffixes))
+		for idx, suffix := range d.numberSuffixes {
+			suffixes[idx] = n.literal(suffix)
+		}
+		number = n.concat(number, n.quest(n.alternate(suffixes...)))
+	}
+	fragments := []fragment{
+		n.concat(n.set(alpha), n.star(n.set(ident))),
+		n.plus(n.set(space)),
+		n.plus(n.set(newline)),
+		n.set(single),
+		number,
+	}
+	if d.numbers&(HexNumbers|OctalNumbers|BinaryNumbers) != 0 {
+		// 0x, 0o or 0b followed by anything that might be a digit.
+		radix := byteSetOf("_")
+		for char := range radix {
+			radix[char] = radix[char] || IsAlpha(byte(char)) || (char >= '0' && char <= '9')
+		}
+		fragments = append(fragments, n.concat(
+			n.quest(n.set(signs)), n.literal("0"), n.set(byteSetOf("xXoObB")), n.star(n.set(radix))))
+	}
+	return fragments
+}
+
+// byteSet is a set of bytes for an NFA transition.
+type byteSet [256]bool
+
+// byteSetOf returns the set of bytes in chars.
+func byteSetOf(chars string) (set byteSet) {
+	for _, char := range []byte(chars) {
+		set[char] = true
+	}
+	return set
+}
+
+// nfaEdge is a transition on any byte from lo to hi.
+type nfaEdge struct {
+	lo, hi byte
+	to     int
+}
+
+// nfaState is a state of a Thompson NFA.
+type nfaState struct {
+	edges  []nfaEdge
+	eps    []int // epsilon transitions
+	accept int   // index of the rule this state accepts, or -1
+}
+
+// nfa is a Thompson NFA under construction.
+type nfa struct {
+	states []nfaState
+}
+
+// fragment is part of an NFA with a single entry and exit state.
+type fragment struct {
+	start, end int
+}
+
+func (n *nfa) state() int {
+	n.states = append(n.states, nfaState{accept: -1})
+	return len(n.states) - 1
+}
+
+func (n *nfa) epsilon(from, to int) {
+	n.states[from].eps = append(n.states[from].eps, to)
+}
+
+// empty returns a fragment that matches empty text.
+func (n *nfa) empty() fragment {
+	state := n.state()
+	return fragment{state, state}
+}
+
+// set returns a fragment matching any one byte of set.
+func (n *nfa) set(set byteSet) fragment {
+	f := fragment{n.state(), n.state()}
+	for char := 0; char < 256; char++ {
+		if !set[char] {
+			continue
+		}
+		hi := char
+		for hi+1 < 256 && set[hi+1] {
+			hi++
+		}
+		n.states[f.start].edges = append(n.states[f.start].edges, nfaEdge{byte(char), byte(hi), f.end})
+		char = hi
+	}
+	return f
+}
+
+// literal returns a fragment matching text.
+func (n *nfa) literal(text string) fragment {
+	f := n.empty()
+	for _, char := range []byte(text) {
+		var set byteSet
+		set[char] = true
+		f = n.concat(f, n.set(set))
+	}
+	return f
+}
+
+// foldedLiteral returns a fragment matching text regardless of case.
+func (n *nfa) foldedLiteral(text string) fragment {
+	f := n.empty()
+	for _, r := range text {
+		f = n.concat(f, n.runes(foldRanges(r)))
+	}
+	return f
+}
+
+// foldRanges returns the ranges matching r and any other case of r.
+func foldRanges(r rune) []rune {
+	ranges := []rune{r, r}
+	for fold := unicode.SimpleFold(r); fold != r; fold = unicode.SimpleFold(fold) {
+		ranges = append(ranges, fold, fold)
+	}
+	return ranges
+}
+
+// concat returns a fragment matching each of parts in sequence.
+func (n *nfa) concat(parts ...fragment) fragment {
+	f := parts[0]
+	for _, part := range parts[1:] {
+		n.epsilon(f.end, part.start)
+		f.end = part.end
+	}
+	return f
+}
+
+// alternate returns a fragment matching any one of choices.
+func (n *nfa) alternate(choices ...fragment) fragment {
+	f := fragment{n.state(), n.state()}
+	for _, choice := range choices {
+		n.epsilon(f.start, choice.start)
+		n.epsilon(choice.end, f.end)
+	}
+	return f
+}
+
+// quest returns a fragment matching f or empty text.
+func (n *nfa) quest(f fragment) fragment {
+	return n.alternate(f, n.empty())
+}
+
+// star returns a fragment matching f any number of times.
+func (n *nfa) star(f fragment) fragment {
+	loop := n.state()
+	n.epsilon(loop, f.start)
+	n.epsilon(f.end, loop)
+	return fragment{loop, loop}
+}
+
+// plus returns a fragment matching f one or more times. The states of f are
+// shared by the first and subsequent repetitions.
+func (n *nfa) plus(f fragment) fragment {
+	return n.concat(f, n.star(f))
+}
+
+// compile returns a fragment matching a parsed regular expression.
+func (n *nfa) compile(re *syntax.Regexp) (fragment, error) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return n.empty(), nil
+
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return n.foldedLiteral(string(re.Rune)), nil
+		}
+		return n.literal(string(re.Rune)), nil
+
+	case syntax.OpCharClass:
+		return n.runes(re.Rune), nil
+
+	case syntax.OpAnyCharNotNL:
+		return n.runes([]rune{0, '\n' - 1, '\n' + 1, unicode.MaxRune}), nil
+
+	case syntax.OpAnyChar:
+		return n.runes([]rune{0, unicode.MaxRune}), nil
+
+	case syntax.OpCapture:
+		return n.compile(re.Sub[0])
+
+	case syntax.OpConcat, syntax.OpAlternate:
+		subs := make([]fragment, len(re.Sub))
+		for idx, sub := range re.Sub {
+			f, err := n.compile(sub)
+			if err != nil {
+				return f, err
+			}
+			subs[idx] = f
+		}
+		if re.Op == syntax.OpConcat {
+			return n.concat(subs...), nil
+		}
+		return n.alternate(subs...), nil
+
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		f, err := n.compile(re.Sub[0])
+		switch {
+		case err != nil:
+			return f, err
+		case re.Op == syntax.OpStar:
+			return n.star(f), nil
+		case re.Op == syntax.OpPlus:
+			return n.plus(f), nil
+		}
+		return n.quest(f), nil
+
+	case syntax.OpNoMatch:
+		// An entry with no way to reach the exit.
+		return fragment{n.state(), n.state()}, nil
+	}
+	return fragment{}, fmt.Errorf("unsupported syntax %s", strconv.Quote(re.String()))
+}
+
+// runes returns a fragment matching the UTF-8 encoding of any rune in ranges,
+// which are pairs of lo, hi as in syntax.Regexp.Rune.
+func (n *nfa) runes(ranges []rune) fragment {
+	f := fragment{n.state(), n.state()}
+	for idx := 0; idx+1 < len(ranges); idx += 2 {
+		for _, sequence := range utf8Sequences(ranges[idx], ranges[idx+1]) {
+			from := f.start
+			for pos, byteRange := range sequence {
+				to := f.end
+				if pos < len(sequence)-1 {
+					to = n.state()
+				}
+				n.states[from].edges = append(n.states[from].edges, nfaEdge{byteRange[0], byteRange[1], to})
+				from = to
+			}
+		}
+	}
+	return f
+}
+
+// nfaSet is a sorted set of NFA states.
+type nfaSet []int
+
+// closure returns the set of states reachable from states by epsilon moves.
+func (n *nfa) closure(states []int) nfaSet {
+	seen := make(map[int]bool, len(states))
+	stack := append([]int(nil), states...)
+	for len(stack) > 0 {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[state] {
+			continue
+		}
+		seen[state] = true
+		stack = append(stack, n.states[state].eps...)
+	}
+	set := make(nfaSet, 0, len(seen))
+	for state := range seen {
+		set = append(set, state)
+	}
+	sort.Ints(set)
+	return set
+}
+
+// key returns a string identifying the set.
+func (set nfaSet) key() string {
+	var key strings.Builder
+	for _, state := range set {
+		key.WriteString(strconv.Itoa(state))
+		key.WriteByte(',')
+	}
+	return key.String()
+}
+
+// accept returns the highest priority (lowest) rule accepted by the set, or -1.
+func (set nfaSet) accept(states []nfaState) int {
+	best := -1
+	for _, state := range set {
+		if accept := states[state].accept; accept >= 0 && (best < 0 || accept < best) {
+			best = accept
+		}
+	}
+	return best
+}
+
+// utf8Sequences returns the sequences of byte ranges that encode the runes
+// from lo to hi, excluding surrogates.
+func utf8Sequences(lo, hi rune) (sequences [][][2]byte) {
+	if lo > hi {
+		return nil
+	}
+	// Surrogates can't be encoded.
+	if lo < 0xD800 && hi > 0xDFFF {
+		return append(utf8Sequences(lo, 0xD7FF), utf8Sequences(0xE000, hi)...)
+	}
+	if lo >= 0xD800 && lo <= 0xDFFF {
+		return utf8Sequences(0xE000, hi)
+	}
+	if hi >= 0xD800 && hi <= 0xDFFF {
+		return utf8Sequences(lo, 0xD7FF)
+	}
+	// Split ranges whose ends have different encoded lengths.
+	for _, max := range []rune{0x7F, 0x7FF, 0xFFFF} {
+		if lo <= max && hi > max {
+			return append(utf8Sequences(lo, max), utf8Sequences(max+1, hi)...)
+		}
+	}
+	if hi < utf8.RuneSelf {
+		return [][][2]byte{{{byte(lo), byte(hi)}}}
+	}
+	// Split until every continuation byte ranges over all of its values or
+	// the leading bytes are identical.
+	size := utf8.RuneLen(lo)
+	for idx := 1; idx < size; idx++ {
+		mask := rune(1)<<(6*uint(idx)) - 1
+		if lo&^mask != hi&^mask {
+			if lo&mask != 0 {
+				return append(utf8Sequences(lo, lo|mask), utf8Sequences((lo|mask)+1, hi)...)
+			}
+			if hi&mask != mask {
+				return append(utf8Sequences(lo, (hi&^mask)-1), utf8Sequences(hi&^mask, hi)...)
+			}
+		}
+	}
+	var loBytes, hiBytes [utf8.UTFMax]byte
+	utf8.EncodeRune(loBytes[:], lo)
+	utf8.EncodeRune(hiBytes[:], hi)
+	sequence := make([][2]byte, size)
+	for idx := range sequence {
+		sequence[idx] = [2]byte{loBytes[idx], hiBytes[idx]}
+	}
+	return [][][2]byte{sequence}
+}
@@ -0,0 +1,222 @@
+package parsing
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	DurationToken = NewToken("DURATION")
+	LowerToken    = NewToken("LOWER")
+	TagToken      = NewToken("TAG")
+	GreekToken    = NewToken("GREEK")
+	patternIf     = NewTerminal("pattern-if")
+	patternSelect = NewTerminal("pattern-select")
+	patternLess   = NewTerminal("pattern-less")
+)
+
+func TestDialect_AddPattern(t *testing.T) {
+	d := NewDialect("patterns")
+	assert.Nil(t, d.dfa())
+	require.NoError(t, d.AddPattern(DurationToken, "[0-9]+(ms|s|m|h)"))
+	automaton := d.dfa()
+	require.NotNil(t, automaton)
+	assert.Same(t, automaton, d.dfa())
+
+	// Changing the dialect's rules discards the DFA.
+	d.AddKeyword("if", patternIf)
+	assert.NotSame(t, automaton, d.dfa())
+
+	tests := []struct {
+		pattern, err string
+	}{
+		{"[a-", "pattern \"[a-\": error parsing regexp: missing closing ]: `[a-`"},
+		{"a*", "pattern \"a*\" matches empty text"},
+		{"^a", "pattern \"^a\": unsupported syntax \"\\\\A\""},
+		{`a\b`, "pattern \"a\\\\b\": unsupported syntax \"\\\\b\""},
+	}
+	for _, tt := range tests {
+		assert.EqualError(t, d.AddPattern(LowerToken, tt.pattern), tt.err)
+	}
+	assert.Len(t, d.patterns, 1)
+}
+
+func TestLexer_patterns(t *testing.T) {
+	d := NewDialect("patterns")
+	d.SetNumberSyntax(AllNumbers)
+	d.AddKeyword("if", patternIf)
+	d.AddOperator("<", patternLess)
+	require.NoError(t, d.AddPattern(DurationToken, "[0-9]+(ms|s|m|h)"))
+	require.NoError(t, d.AddPattern(TagToken, "<[a-z]+>"))
+	require.NoError(t, d.AddPattern(LowerToken, "[a-z]+"))
+	require.NoError(t, d.AddPattern(GreekToken, "[α-ω]+|pi"))
+	require.NoError(t, d.AddPattern(patternSelect, "(?i)select"))
+
+	tests := []struct {
+		code   string
+		tokens []Token
+		values []string
+	}{
+		{"10ms 5 1.5s", []Token{DurationToken, IntegerToken, FloatToken, LowerToken}, []string{"10ms", "5", "1.5", "s"}},
+		{"0x1fh 2h", []Token{IntegerToken, LowerToken, DurationToken}, []string{"0x1f", "h", "2h"}},
+		{"if iffy If", []Token{patternIf, LowerToken, IdentifierToken}, []string{"if", "iffy", "If"}},
+		{"<a> < a <", []Token{TagToken, patternLess, LowerToken, patternLess}, []string{"<a>", "<", "a", "<"}},
+		{"<aB>", []Token{patternLess, IdentifierToken, SymbolToken}, []string{"<", "aB", ">"}},
+		// "pi" matches LOWER as well as GREEK, and LOWER was added first.
+		{"αβγ pi pie", []Token{GreekToken, LowerToken, LowerToken}, []string{"αβγ", "pi", "pie"}},
+		{"SeLeCt selection", []Token{patternSelect, LowerToken}, []string{"SeLeCt", "selection"}},
+		{"x_1 'a b' // c", []Token{IdentifierToken, StringToken}, []string{"x_1", "'a b'"}},
+		{"-5 +.5e3", []Token{IntegerToken, FloatToken}, []string{"-5", "+.5e3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			tokens, values := lexTokens(d.NewLexer("patterns.test", []byte(tt.code)))
+			assert.Equal(t, tt.tokens, tokens)
+			assert.Equal(t, tt.values, values)
+		})
+	}
+
+	t.Run("lexer keywords", func(t *testing.T) {
+		l := d.NewLexer("local.test", []byte("abc ABC"))
+		l.AddKeyword("abc", patternSelect)
+		l.AddKeyword("ABC", patternIf)
+		tokens, _ := lexTokens(l)
+		assert.Equal(t, []Token{LowerToken, patternIf}, tokens)
+	})
+
+	t.Run("pattern order", func(t *testing.T) {
+		d := NewDialect("order")
+		require.NoError(t, d.AddPattern(TagToken, "ab|cd"))
+		require.NoError(t, d.AddPattern(LowerToken, "[a-z]{2}"))
+		tokens, _ := lexTokens(d.NewLexer("order.test", []byte("ab xy cd")))
+		assert.Equal(t, []Token{TagToken, LowerToken, TagToken}, tokens)
+	})
+
+	t.Run("sign operator", func(t *testing.T) {
+		d := NewDialect("signs")
+		d.SetNumberSyntax(Exponents)
+		d.AddOperator("-", Minus)
+		require.NoError(t, d.AddPattern(DurationToken, "[0-9]+(ms|s|m|h)"))
+		tokens, values := lexTokens(d.NewLexer("signs.test", []byte("-1 +2 1e-3")))
+		assert.Equal(t, []Token{Minus, IntegerToken, IntegerToken, FloatToken}, tokens)
+		assert.Equal(t, []string{"-", "1", "+2", "1e-3"}, values)
+	})
+
+	t.Run("mode", func(t *testing.T) {
+		l := NewLexer("mode.test", []byte("10ms"))
+		tokens, _ := lexTokens(l)
+		assert.Equal(t, []Token{IntegerToken, IdentifierToken}, tokens)
+		l = NewLexer("mode.test", []byte("10ms"))
+		l.PushMode(d)
+		tokens, _ = lexTokens(l)
+		assert.Equal(t, []Token{DurationToken}, tokens)
+	})
+}
+
+// TestDialect_dfa compares the DFA's longest match against the regexp package.
+func TestDialect_dfa(t *testing.T) {
+	patterns := []string{
+		"[0-9]+(ms|s|m|h)", "a(b|c)*d", "x?y+z{2,3}", "[^a-z]+", "(?i)straße", ".é.", "[α-ωΑ-Ω]+[0-9]?", "(ab)+|a(ba)*",
+	}
+	alphabet := []rune("abcdxyzmsh0129 éÉαΩ.straßeSTRASSE\n")
+	random := rand.New(rand.NewSource(1))
+	for _, source := range patterns {
+		d := NewDialect("dfa")
+		d.SetClass('\n', InvalidToken)
+		require.NoError(t, d.AddPattern(LowerToken, source))
+		automaton := d.dfa()
+		re := regexp.MustCompile("^(?:" + source + ")")
+		re.Longest()
+		for i := 0; i < 500; i++ {
+			var text strings.Builder
+			for n := random.Intn(8); n >= 0; n-- {
+				text.WriteRune(alphabet[random.Intn(len(alphabet))])
+			}
+			l := d.NewLexer("dfa.test", []byte(text.String()))
+			rule, length := automaton.match(l, 0)
+			want := len(re.FindString(text.String()))
+			if want > 0 {
+				if assert.NotNil(t, rule, "%s: %q", source, text.String()) && !rule.base {
+					assert.Equal(t, want, length, "%s: %q", source, text.String())
+				}
+			} else if rule != nil {
+				assert.True(t, rule.base, "%s: %q", source, text.String())
+			}
+		}
+	}
+}
+
+func Test_utf8Sequences(t *testing.T) {
+	ranges := [][2]rune{{0, 0x7F}, {0x70, 0x900}, {0xD000, 0xE100}, {0xFFF0, 0x10010}, {0x10FF00, utf8.MaxRune}}
+	for _, r := range ranges {
+		sequences := utf8Sequences(r[0], r[1])
+		matches := func(encoded []byte) bool {
+		sequence:
+			for _, sequence := range sequences {
+				if len(sequence) != len(encoded) {
+					continue
+				}
+				for idx, char := range encoded {
+					if char < sequence[idx][0] || char > sequence[idx][1] {
+						continue sequence
+					}
+				}
+				return true
+			}
+			return false
+		}
+		var encoded [utf8.UTFMax]byte
+		for ch := r[0] - 0x20; ch <= r[1]+0x20; ch++ {
+			if ch < 0 || ch > utf8.MaxRune || (ch >= 0xD800 && ch <= 0xDFFF) {
+				continue
+			}
+			n := utf8.EncodeRune(encoded[:], ch)
+			assert.Equal(t, ch >= r[0] && ch <= r[1], matches(encoded[:n]), "%U in %U-%U", ch, r[0], r[1])
+		}
+	}
+}
+
+const benchmarkDurations = "10ms 250s 3h 15m 7 retry 99ms "
+
+func BenchmarkLexer_pattern(b *testing.B) {
+	d := NewDialect("pattern")
+	if err := d.AddPattern(DurationToken, "[0-9]+(ms|s|m|h)"); err != nil {
+		b.Fatal(err)
+	}
+	code := []byte(strings.Repeat(benchmarkDurations, 100))
+	b.SetBytes(int64(len(code)))
+	for i := 0; i < b.N; i++ {
+		l := d.NewLexer("bench", code)
+		for l.Advance() {
+		}
+	}
+}
+
+func BenchmarkLexer_intercept(b *testing.B) {
+	code := []byte(strings.Repeat(benchmarkDurations, 100))
+	b.SetBytes(int64(len(code)))
+	for i := 0; i < b.N; i++ {
+		l := NewLexer("bench", code)
+		l.AddIntercept(DigitToken, func(l *Lexer) bool {
+			for char, ok := l.Peek(); ok && TokenMap[char] == DigitToken; char, ok = l.Peek() {
+				l.Skip()
+			}
+			for _, unit := range []string{"ms", "s", "m", "h"} {
+				if l.hasPrefix(l.End, unit) {
+					l.End += len(unit)
+					l.Token = DurationToken
+					return true
+				}
+			}
+			return false
+		})
+		for l.Advance() {
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package parsing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Preprocessor is a SymbolSource that implements C-style conditional
+// compilation and object-like macros between a Lexer and a Parser:
+//
+//	#define NAME replacement...
+//	#undef NAME
+//	#if expression / #ifdef NAME / #ifndef NAME
+//	#elif expression
+//	#else
+//	#endif
+//
+// Directives begin with a '#' that is the first token on its line and run to
+// the end of the line. Expressions are integer arithmetic as in C, where
+// "defined NAME" or "defined(NAME)" is 1 for a macro and 0 otherwise, and
+// any other name that isn't a macro is 0.
+//
+// Symbols are returned with their original positions in the source. Macro
+// replacements are positioned at the name they replace. Directives and the
+// code in inactive branches become trivia, DirectiveToken and SkippedToken
+// Symbols, so the Symbols' Text reproduces the source except where a macro
+// was replaced, which reads as its replacement.
+//
+// Errors are reported through the Lexer, see Lexer.SetRecovery. A Parser
+// reading from a Preprocessor can't backtrack with Mark and Reset or change
+// modes with PushMode, since the preprocessor's state isn't restored.
+type Preprocessor struct {
+	lexer        *Lexer
+	defines      map[string][]Symbol // replacement of each macro
+	conditionals []conditional       // open #if blocks, innermost last
+	trivia       []Symbol            // trivia to return before the next symbol
+	queue        []Symbol            // symbols from a replacement still to return
+	lineEnded    bool                // the next symbol begins a line
+	unread       *unreadSymbol       // symbol to return again from read
+}
+
+// conditional describes an open #if, #ifdef or #ifndef block.
+type conditional struct {
+	directive  string // name of the opening directive
+	start, end int    // location of the opening directive
+	active     bool   // whether the current branch is compiled
+	taken      bool   // whether any branch has been compiled
+	sawElse    bool   // whether the #else branch has begun
+}
+
+// unreadSymbol holds a symbol that the preprocessor read too far ahead.
+type unreadSymbol struct {
+	symbol    Symbol
+	trivia    []Symbol
+	lineStart bool
+}
+
+// NewPreprocessor constructs a Preprocessor that reads from a Lexer.
+func NewPreprocessor(l *Lexer) *Preprocessor {
+	return &Preprocessor{lexer: l, defines: make(map[string][]Symbol), lineEnded: true}
+}
+
+// NewParser constructs a Parser that reads preprocessed symbols.
+func (pp *Preprocessor) NewParser(rules ...Rule) *Parser {
+	return newParser(pp.lexer, pp, rules)
+}
+
+// Define defines an object-like macro, as though by "#define name value".
+// The value is lexed using the rules of the lexer's Dialect.
+func (pp *Preprocessor) Define(name, value string) error {
+	if !isMacroName(name) {
+		return fmt.Errorf("invalid macro name: %q", name)
+	}
+	replacement, err := Tokenize(pp.lexer.Dialect().NewLexer("<define "+name+">", []byte(value)), false)
+	if err != nil {
+		return err
+	}
+	pp.defines[name] = replacement
+	return nil
+}
+
+// DefineArgs defines macros given as "NAME" or "NAME=value", such as from
+// the Defines command-line option. NAME alone defines NAME as 1.
+func (pp *Preprocessor) DefineArgs(args []string) error {
+	for _, arg := range args {
+		name, value := arg, "1"
+		if idx := strings.IndexByte(arg, '='); idx >= 0 {
+			name, value = arg[:idx], arg[idx+1:]
+		}
+		if err := pp.Define(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Undefine removes a macro definition, as though by "#undef name".
+func (pp *Preprocessor) Undefine(name string) {
+	delete(pp.defines, name)
+}
+
+// Defined returns true if name is currently defined as a macro.
+func (pp *Preprocessor) Defined(name string) bool {
+	_, ok := pp.defines[name]
+	return ok
+}
+
+// NextSymbol returns the next significant Symbol after preprocessing, with
+// the trivia that precedes it, see SymbolSource.
+func (pp *Preprocessor) NextSymbol() (symbol Symbol, trivia []Symbol) {
+	for len(pp.queue) == 0 {
+		symbol, trivia, lineStart := pp.read()
+		pp.trivia = append(pp.trivia, trivia...)
+		switch {
+		case symbol.Token == EOFToken:
+			pp.endOfFile()
+			pp.queue = append(pp.queue, symbol)
+		case lineStart && symbol.Value == "#":
+			pp.directive(symbol)
+		case !pp.active():
+			symbol.Token = SkippedToken
+			pp.trivia = append(pp.trivia, symbol)
+		default:
+			pp.queue = pp.replace(symbol)
+		}
+	}
+	symbol, pp.queue = pp.queue[0], pp.queue[1:]
+	trivia, pp.trivia = pp.trivia, nil
+	return symbol, trivia
+}
+
+// read returns the next symbol from the lexer, the trivia before it and
+// whether it is the first symbol on its line.
+func (pp *Preprocessor) read() (symbol Symbol, trivia []Symbol, lineStart bool) {
+	if unread := pp.unread; unread != nil {
+		pp.unread = nil
+		return unread.symbol, unread.trivia, unread.lineStart
+	}
+	symbol, trivia = pp.lexer.NextSymbol()
+	lineStart = pp.lineEnded
+	for _, trivium := range trivia {
+		lineStart = lineStart || trivium.Token == NewlineToken
+	}
+	// In indentation mode, INDENT and DEDENT precede the first symbol of a line.
+	pp.lineEnded = symbol.Token == EOLToken ||
+		lineStart && (symbol.Token == IndentToken || symbol.Token == DedentToken)
+	return symbol, trivia, lineStart
+}
+
+// active returns true if symbols are currently being compiled.
+func (pp *Preprocessor) active() bool {
+	return len(pp.conditionals) == 0 || pp.conditionals[len(pp.conditionals)-1].active
+}
+
+// replace returns the symbols that replace symbol if it names a macro,
+// positioned at symbol, or symbol itself otherwise.
+func (pp *Preprocessor) replace(symbol Symbol) []Symbol {
+	if !pp.Defined(symbol.Value) {
+		return []Symbol{symbol}
+	}
+	replacement := pp.expand(symbol, nil)
+	for idx := range replacement {
+		replacement[idx].StartOffset, replacement[idx].EndOffset = symbol.StartOffset, symbol.EndOffset
+		replacement[idx].Pos, replacement[idx].mode, replacement[idx].origin = symbol.Pos, symbol.mode, symbol.origin
+	}
+	return replacement
+}
+
+// expand returns the replacement of symbol if it names a macro, with any
+// macros in the replacement also expanded, or symbol itself otherwise. As in
+// C, a macro is not expanded again within its own replacement (hidden).
+func (pp *Preprocessor) expand(symbol Symbol, hidden []string) []Symbol {
+	definition, ok := pp.defines[symbol.Value]
+	if !ok {
+		return []Symbol{symbol}
+	}
+	for _, name := range hidden {
+		if name == symbol.Value {
+			return []Symbol{symbol}
+		}
+	}
+	hidden = append(hidden[:len(hidden):len(hidden)], symbol.Value)
+	replacement := make([]Symbol, 0, len(definition))
+	for _, symbol := range definition {
+		replacement = append(replacement, pp.expand(symbol, hidden)...)
+	}
+	return replacement
+}
+
+// directive reads the remainder of a directive line, adds it to the trivia
+// as a DirectiveToken and carries it out.
+func (pp *Preprocessor) directive(hash Symbol) {
+	var text strings.Builder
+	text.WriteString(hash.Value)
+	directive := hash
+	var words, tail []Symbol
+	for {
+		symbol, trivia, lineStart := pp.read()
+		if lineStart || symbol.Token == EOFToken {
+			pp.unread = &unreadSymbol{symbol, trivia, lineStart}
+			break
+		}
+		if symbol.Token == EOLToken {
+			// In indentation mode, the end of the directive is trivia.
+			symbol.Token = NewlineToken
+			tail = append(trivia, symbol)
+			break
+		}
+		for _, trivium := range trivia {
+			text.WriteString(trivium.Value)
+		}
+		text.WriteString(symbol.Value)
+		directive.EndOffset = symbol.EndOffset
+		words = append(words, symbol)
+	}
+	directive.Token, directive.Value = DirectiveToken, text.String()
+	pp.trivia = append(append(pp.trivia, directive), tail...)
+	if len(words) > 0 {
+		pp.execute(directive, words[0].Value, words[1:])
+	}
+}
+
+// execute carries out a directive.
+func (pp *Preprocessor) execute(directive Symbol, name string, args []Symbol) {
+	switch name {
+	case "if", "ifdef", "ifndef":
+		block := conditional{directive: name, start: directive.StartOffset, end: directive.EndOffset, taken: true}
+		if pp.active() {
+			block.active = pp.test(directive, name, args)
+			block.taken = block.active
+		}
+		pp.conditionals = append(pp.conditionals, block)
+
+	case "elif", "else":
+		block := pp.innermost(directive, name)
+		if block == nil {
+			return
+		}
+		if block.sawElse {
+			pp.errorf(directive, "#%s after #else", name)
+		}
+		block.active = !block.taken && pp.test(directive, name, args)
+		block.taken = block.taken || block.active
+		block.sawElse = name == "else"
+
+	case "endif":
+		if pp.innermost(directive, name) != nil {
+			pp.conditionals = pp.conditionals[:len(pp.conditionals)-1]
+		}
+
+	case "define", "undef":
+		if !pp.active() {
+			return
+		}
+		if len(args) == 0 || !isMacroName(args[0].Value) {
+			pp.errorf(directive, "#%s requires a macro name", name)
+			return
+		}
+		if name == "undef" {
+			pp.Undefine(args[0].Value)
+			return
+		}
+		if len(args) > 1 && args[1].Value == "(" && args[1].StartOffset == args[0].EndOffset {
+			pp.errorf(directive, "function-like macros are not supported")
+			return
+		}
+		pp.defines[args[0].Value] = args[1:]
+
+	default:
+		if pp.active() {
+			pp.errorf(directive, "unknown directive #%s", name)
+		}
+	}
+}
+
+// innermost returns the innermost open #if block, or reports an error if
+// there isn't one.
+func (pp *Preprocessor) innermost(directive Symbol, name string) *conditional {
+	if len(pp.conditionals) == 0 {
+		pp.errorf(directive, "#%s without #if", name)
+		return nil
+	}
+	return &pp.conditionals[len(pp.conditionals)-1]
+}
+
+// test evaluates the condition of a directive.
+func (pp *Preprocessor) test(directive Symbol, name string, args []Symbol) bool {
+	switch name {
+	case "else":
+		return true
+	case "ifdef", "ifndef":
+		if len(args) == 0 || !isMacroName(args[0].Value) {
+			pp.errorf(directive, "#%s requires a macro name", name)
+			return false
+		}
+		return pp.Defined(args[0].Value) == (name == "ifdef")
+	}
+	value, err := pp.evaluate(args)
+	if err != nil {
+		pp.errorf(directive, "invalid expression in #%s: %s", name, err)
+		return false
+	}
+	return value != 0
+}
+
+// endOfFile reports any #if blocks left open.
+func (pp *Preprocessor) endOfFile() {
+	conditionals := pp.conditionals
+	pp.conditionals = nil
+	for _, block := range conditionals {
+		pp.lexer.fatalAt(block.start, block.end, "unterminated #%s", block.directive)
+	}
+}
+
+// errorf reports an error with a directive through the lexer.
+func (pp *Preprocessor) errorf(directive Symbol, msg string, args ...interface{}) {
+	pp.lexer.fatalAt(directive.StartOffset, directive.EndOffset, msg, args...)
+}
+
+// isMacroName returns true if name is a valid C identifier.
+func isMacroName(name string) bool {
+	for idx := 0; idx < len(name); idx++ {
+		char := name[idx]
+		switch {
+		case char == '_', char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z':
+		case char >= '0' && char <= '9' && idx > 0:
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// expressionOperators are the operators of #if expressions that are made of
+// two symbols, which lexers usually return separately.
+var expressionOperators = map[string]bool{
+	"||": true, "&&": true, "==": true, "!=": true, "<=": true, ">=": true, "<<": true, ">>": true,
+}
+
+// binaryOperators are the binary operators of #if expressions, from lowest
+// to highest precedence.
+var binaryOperators = [][]string{
+	{"||"}, {"&&"}, {"|"}, {"^"}, {"&"}, {"==", "!="}, {"<", ">", "<=", ">="}, {"<<", ">>"}, {"+", "-"}, {"*", "/", "%"},
+}
+
+// expression is an #if expression reduced to the values of its symbols.
+type expression struct {
+	words []string
+	pos   int
+}
+
+// expressionError describes a problem evaluating an expression.
+type expressionError string
+
+func (e expressionError) Error() string { return string(e) }
+
+// evaluate returns the value of an #if expression.
+func (pp *Preprocessor) evaluate(args []Symbol) (value int64, err error) {
+	var symbols []Symbol
+	for idx := 0; idx < len(args); idx++ {
+		if args[idx].Value != "defined" {
+			symbols = append(symbols, pp.expand(args[idx], nil)...)
+			continue
+		}
+		// The operand of defined is not expanded.
+		operand := args[idx+1:]
+		if len(operand) >= 3 && operand[0].Value == "(" && operand[2].Value == ")" {
+			idx += 3
+			operand = operand[1:]
+		} else {
+			idx++
+		}
+		if len(operand) == 0 || !isMacroName(operand[0].Value) {
+			return 0, expressionError("defined requires a macro name")
+		}
+		result := Symbol{Token: IntegerToken, Value: "0", StartOffset: -1, EndOffset: -1}
+		if pp.Defined(operand[0].Value) {
+			result.Value = "1"
+		}
+		symbols = append(symbols, result)
+	}
+
+	expr := &expression{}
+	for idx, symbol := range symbols {
+		words := len(expr.words)
+		if idx > 0 && symbols[idx-1].EndOffset == symbol.StartOffset && symbol.StartOffset >= 0 &&
+			expressionOperators[expr.words[words-1]+symbol.Value] {
+			expr.words[words-1] += symbol.Value
+			continue
+		}
+		// Lexers with signed numbers read "X-1" as X followed by -1, but the
+		// sign of "-1" in "X * -1" is unary and belongs with the number.
+		if value := symbol.Value; (symbol.Token == IntegerToken || symbol.Token == FloatToken) &&
+			len(value) > 1 && (value[0] == '-' || value[0] == '+') && words > 0 && endsOperand(expr.words[words-1]) {
+			expr.words = append(expr.words, value[:1], value[1:])
+			continue
+		}
+		expr.words = append(expr.words, symbol.Value)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			exprErr, ok := r.(expressionError)
+			if !ok {
+				panic(r)
+			}
+			err = exprErr
+		}
+	}()
+	if len(expr.words) == 0 {
+		expr.fail("missing expression")
+	}
+	value = expr.binary(0)
+	if expr.pos < len(expr.words) {
+		expr.fail("unexpected %q", expr.words[expr.pos])
+	}
+	return value, nil
+}
+
+// endsOperand returns true if word is a value, a name or the ")" that closes
+// a parenthesized expression.
+func endsOperand(word string) bool {
+	return word == ")" || word[0] == '_' || IsAlpha(word[0]) || (word[0] >= '0' && word[0] <= '9')
+}
+
+// fail abandons evaluation of the expression with an error.
+func (e *expression) fail(msg string, args ...interface{}) {
+	panic(expressionError(fmt.Sprintf(msg, args...)))
+}
+
+// next returns the next word of the expression.
+func (e *expression) next() string {
+	if e.pos >= len(e.words) {
+		e.fail("unexpected end of expression")
+	}
+	e.pos++
+	return e.words[e.pos-1]
+}
+
+// binary evaluates operators with at least the precedence of level.
+func (e *expression) binary(level int) int64 {
+	if level == len(binaryOperators) {
+		return e.unary()
+	}
+	value := e.binary(level + 1)
+	for e.pos < len(e.words) {
+		op := e.words[e.pos]
+		matched := false
+		for _, candidate := range binaryOperators[level] {
+			matched = matched || op == candidate
+		}
+		if !matched {
+			break
+		}
+		e.pos++
+		rhs := e.binary(level + 1)
+		switch op {
+		case "||":
+			value = boolValue(value != 0 || rhs != 0)
+		case "&&":
+			value = boolValue(value != 0 && rhs != 0)
+		case "|":
+			value |= rhs
+		case "^":
+			value ^= rhs
+		case "&":
+			value &= rhs
+		case "==":
+			value = boolValue(value == rhs)
+		case "!=":
+			value = boolValue(value != rhs)
+		case "<":
+			value = boolValue(value < rhs)
+		case ">":
+			value = boolValue(value > rhs)
+		case "<=":
+			value = boolValue(value <= rhs)
+		case ">=":
+			value = boolValue(value >= rhs)
+		case "<<", ">>":
+			if rhs < 0 {
+				e.fail("negative shift count %d", rhs)
+			}
+			if op == "<<" {
+				value <<= uint64(rhs)
+			} else {
+				value >>= uint64(rhs)
+			}
+		case "+":
+			value += rhs
+		case "-":
+			value -= rhs
+		case "*":
+			value *= rhs
+		case "/", "%":
+			if rhs == 0 {
+				e.fail("division by zero")
+			}
+			if op == "/" {
+				value /= rhs
+			} else {
+				value %= rhs
+			}
+		}
+	}
+	return value
+}
+
+// unary evaluates a unary operator, parenthesized expression or value.
+func (e *expression) unary() int64 {
+	word := e.next()
+	switch word {
+	case "!":
+		return boolValue(e.unary() == 0)
+	case "-":
+		return -e.unary()
+	case "+":
+		return e.unary()
+	case "~":
+		return ^e.unary()
+	case "(":
+		value := e.binary(0)
+		if e.next() != ")" {
+			e.fail("missing %q", ")")
+		}
+		return value
+	}
+	if isMacroName(word) {
+		// Names that aren't macros evaluate to 0.
+		return 0
+	}
+	value, err := strconv.ParseInt(strings.TrimRight(word, "uUlL"), 0, 64)
+	if err != nil {
+		e.fail("unexpected %q", word)
+	}
+	return value
+}
+
+// boolValue returns 1 for true and 0 for false.
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
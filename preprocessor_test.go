@@ -0,0 +1,255 @@
+package parsing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// preprocess returns the values of the significant symbols of code after
+// preprocessing with the given command-line style defines.
+func preprocess(t *testing.T, code string, defines ...string) []string {
+	pp := NewPreprocessor(NewLexer("pp.test", []byte(code)))
+	require.NoError(t, pp.DefineArgs(defines))
+	var values []string
+	for {
+		symbol, _ := pp.NextSymbol()
+		if symbol.Token == EOFToken {
+			return values
+		}
+		values = append(values, symbol.Value)
+	}
+}
+
+func TestPreprocessor_conditionals(t *testing.T) {
+	tests := []struct {
+		name, code string
+		defines    []string
+		want       []string
+	}{
+		{"no directives", "a b\nc", nil, []string{"a", "b", "c"}},
+		{"ifdef undefined", "a\n#ifdef DEBUG\nb\n#endif\nc\n", nil, []string{"a", "c"}},
+		{"ifdef defined", "a\n#ifdef DEBUG\nb\n#endif\nc\n", []string{"DEBUG"}, []string{"a", "b", "c"}},
+		{"ifndef", "#ifndef DEBUG\na\n#else\nb\n#endif\n", nil, []string{"a"}},
+		{"else", "#ifdef DEBUG\na\n#else\nb\n#endif\n", nil, []string{"b"}},
+		{"elif", "#if X == 1\na\n#elif X == 2\nb\n#elif X == 2\nc\n#else\nd\n#endif\n", []string{"X=2"}, []string{"b"}},
+		{"nested inactive", "#if 0\n#if 1\na\n#else\nb\n#endif\n#else\nc\n#endif\n", nil, []string{"c"}},
+		{"nested active", "#if 1\n#ifdef A\na\n#elif defined(B)\nb\n#endif\n#endif\n", []string{"B"}, []string{"b"}},
+		{"null directive", "#\na\n", nil, []string{"a"}},
+		{"hash not at line start", "a # b\n", nil, []string{"a", "#", "b"}},
+		{"inactive unknown directive", "#if 0\n#pragma once\n#endif\na", nil, []string{"a"}},
+		{"no final newline", "#ifdef A\na\n#endif", nil, nil},
+		{"signed operand", "#if X-1\na\n#else\nb\n#endif\n", []string{"X=1"}, []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, preprocess(t, tt.code, tt.defines...))
+		})
+	}
+}
+
+func TestPreprocessor_evaluate(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int64
+		err  string
+	}{
+		{"1", 1, ""},
+		{"10 + 14", 24, ""},
+		{"1 + 2 * 3", 7, ""},
+		{"(1 + 2) * 3", 9, ""},
+		{"-4 / 2 % 3", -2, ""},
+		{"2-1", 1, ""},
+		{"VERSION-1", 2, ""},
+		{"VERSION+1", 4, ""},
+		{"X-1", -1, ""},
+		{"- -1", 1, ""},
+		{"2 * -3", -6, ""},
+		{"(1)-1", 0, ""},
+		{"-9223372036854775808", -9223372036854775808, ""},
+		{"1 << 4 >> 2", 4, ""},
+		{"6 & 3 | 8 ^ 1", 11, ""},
+		{"~0", -1, ""},
+		{"!0 && !!2", 1, ""},
+		{"0 || 0", 0, ""},
+		{"1 < 2 && 2 <= 2 && 3 > 2 && 3 >= 4", 0, ""},
+		{"1 == 1 && 1 != 2", 1, ""},
+		{"UNDEFINED", 0, ""},
+		{"VERSION >= 3", 1, ""},
+		{"TWICE", 6, ""},
+		{"defined VERSION && defined(TWICE) && !defined UNDEFINED", 1, ""},
+		{"", 0, "missing expression"},
+		{"1 +", 0, "unexpected end of expression"},
+		{"(1", 0, "unexpected end of expression"},
+		{"1 2", 0, `unexpected "2"`},
+		{"1 / 0", 0, "division by zero"},
+		{"1 << -1", 0, "negative shift count -1"},
+		{"8 >> -2", 0, "negative shift count -2"},
+		{"defined", 0, "defined requires a macro name"},
+		{`"s"`, 0, `unexpected "\"s\""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			pp := NewPreprocessor(NewLexer("expr.test", nil))
+			require.NoError(t, pp.DefineArgs([]string{"VERSION=3", "TWICE=VERSION+VERSION"}))
+			args, err := Tokenize(NewLexer("expr.test", []byte(tt.expr)), false)
+			require.NoError(t, err)
+			value, err := pp.evaluate(args)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, value)
+			}
+		})
+	}
+}
+
+func TestPreprocessor_macros(t *testing.T) {
+	tests := []struct {
+		name, code string
+		want       []string
+	}{
+		{"object-like", "#define SIZE 64\nbuffer[SIZE]", []string{"buffer", "[", "64", "]"}},
+		{"several symbols", "#define CALL f(1)\nCALL;", []string{"f", "(", "1", ")", ";"}},
+		{"nested", "#define A B + B\n#define B 2\nA", []string{"2", "+", "2"}},
+		{"self reference", "#define X X + 1\nX", []string{"X", "+", "1"}},
+		{"mutual reference", "#define A B\n#define B A\nA B", []string{"A", "B"}},
+		{"empty", "#define EMPTY\na EMPTY b", []string{"a", "b"}},
+		{"undef", "#define A 1\nA\n#undef A\nA", []string{"1", "A"}},
+		{"inactive define", "#if 0\n#define A 1\n#endif\nA", []string{"A"}},
+		{"in condition", "#define LEVEL 2\n#if LEVEL > 1\nyes\n#endif", []string{"yes"}},
+		{"not in strings", "#define A 1\n\"A\"", []string{`"A"`}},
+		{"command line", "MODE", []string{"fast"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, preprocess(t, tt.code, "MODE=fast"))
+		})
+	}
+}
+
+func TestPreprocessor_Define(t *testing.T) {
+	pp := NewPreprocessor(NewLexer("define.test", nil))
+	assert.NoError(t, pp.Define("A", "1 + 2"))
+	assert.True(t, pp.Defined("A"))
+	assert.False(t, pp.Defined("B"))
+	pp.Undefine("A")
+	assert.False(t, pp.Defined("A"))
+
+	assert.EqualError(t, pp.Define("1A", ""), `invalid macro name: "1A"`)
+	assert.EqualError(t, pp.Define("", ""), `invalid macro name: ""`)
+	assert.EqualError(t, pp.Define("S", `"unterminated`), `<define S>:1:1-1:14: error: unterminated string/missing close-quote?`)
+
+	assert.NoError(t, pp.DefineArgs([]string{"X", "Y=", "Z=a=b"}))
+	assert.Equal(t, []string{"1"}, symbolValues(pp.defines["X"]))
+	assert.Empty(t, pp.defines["Y"])
+	assert.Equal(t, []string{"a", "=", "b"}, symbolValues(pp.defines["Z"]))
+	assert.EqualError(t, pp.DefineArgs([]string{"=1"}), `invalid macro name: ""`)
+}
+
+// symbolValues returns the Value of each symbol.
+func symbolValues(symbols []Symbol) (values []string) {
+	for _, symbol := range symbols {
+		values = append(values, symbol.Value)
+	}
+	return values
+}
+
+func TestPreprocessor_errors(t *testing.T) {
+	tests := []struct {
+		name, code, err string
+	}{
+		{"unterminated", "a\n#if 1\nb\n", "errors.test:2:1-2:6: error: unterminated #if"},
+		{"endif without if", "#endif\n", "errors.test:1:1-1:7: error: #endif without #if"},
+		{"else without if", "#else\n", "errors.test:1:1-1:6: error: #else without #if"},
+		{"else after else", "#if 1\n#else\n#else\n#endif\n", "errors.test:3:1-3:6: error: #else after #else"},
+		{"elif after else", "#if 1\n#else\n#elif 1\n#endif\n", "errors.test:3:1-3:8: error: #elif after #else"},
+		{"unknown", "#pragma once\n", "errors.test:1:1-1:13: error: unknown directive #pragma"},
+		{"define name", "#define 1\n", "errors.test:1:1-1:10: error: #define requires a macro name"},
+		{"ifdef name", "#ifdef\n#endif\n", "errors.test:1:1-1:7: error: #ifdef requires a macro name"},
+		{"function-like", "#define F(x) x\n", "errors.test:1:1-1:15: error: function-like macros are not supported"},
+		{"expression", "#if 1 +\n#endif\n", "errors.test:1:1-1:8: error: invalid expression in #if: unexpected end of expression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPreprocessor(NewLexer("errors.test", []byte(tt.code)))
+			assert.PanicsWithError(t, tt.err, func() {
+				for symbol, _ := p.NextSymbol(); symbol.Token != EOFToken; symbol, _ = p.NextSymbol() {
+				}
+			})
+		})
+	}
+
+	t.Run("recovery", func(t *testing.T) {
+		l := NewLexer("errors.test", []byte("#endif\na\n#if 1 +\nb\n#endif\n#if 1\nc"))
+		l.SetRecovery(true)
+		assert.Equal(t, []string{"a", "c"}, symbolValues(tokensOf(NewPreprocessor(l))))
+		assert.EqualError(t, l.Err(), strings.Join([]string{
+			"errors.test:1:1-1:7: error: #endif without #if",
+			"errors.test:3:1-3:8: error: invalid expression in #if: unexpected end of expression",
+			"errors.test:6:1-6:6: error: unterminated #if",
+		}, "\n"))
+	})
+
+	t.Run("recovery after partial evaluation", func(t *testing.T) {
+		// The expression fails after evaluating 1.
+		l := NewLexer("errors.test", []byte("#if 1 2\na\n#else\nb\n#endif\n"))
+		l.SetRecovery(true)
+		assert.Equal(t, []string{"b"}, symbolValues(tokensOf(NewPreprocessor(l))))
+		assert.EqualError(t, l.Err(), `errors.test:1:1-1:8: error: invalid expression in #if: unexpected "2"`)
+	})
+}
+
+// tokensOf returns the significant symbols from a source up to EOF.
+func tokensOf(source SymbolSource) (symbols []Symbol) {
+	for {
+		symbol, _ := source.NextSymbol()
+		if symbol.Token == EOFToken {
+			return symbols
+		}
+		symbols = append(symbols, symbol)
+	}
+}
+
+func TestPreprocessor_NewParser(t *testing.T) {
+	code := "#define LIMIT 10\n/* loop */\n#ifdef DEBUG\ntrace(i)\n#endif\nfor i < LIMIT\n"
+	pp := NewPreprocessor(NewLexer("parser.test", []byte(code)))
+	p := pp.NewParser()
+
+	var values, locations, text []string
+	for ; !p.EOF(); p.Next() {
+		values = append(values, p.Current().Value)
+		locations = append(locations, p.Locate(p.Current()))
+		text = append(text, p.Current().Text())
+	}
+	text = append(text, p.Current().Text())
+	assert.Equal(t, []string{"for", "i", "<", "10"}, values)
+	assert.Equal(t, []string{"parser.test:6:1", "parser.test:6:5", "parser.test:6:7", "parser.test:6:9"}, locations)
+
+	// Directives and skipped code are trivia.
+	leading := p.Current()
+	p = NewPreprocessor(NewLexer("parser.test", []byte(code))).NewParser()
+	var tokens []Token
+	for _, trivia := range p.Current().Leading {
+		tokens = append(tokens, trivia.Token)
+	}
+	assert.Equal(t, []Token{DirectiveToken, NewlineToken, CommentToken, NewlineToken, DirectiveToken, NewlineToken,
+		SkippedToken, SkippedToken, SkippedToken, SkippedToken, NewlineToken, DirectiveToken, NewlineToken}, tokens)
+	assert.Equal(t, "#define LIMIT 10", p.Current().Leading[0].Value)
+	assert.Equal(t, EOFToken, leading.Token)
+
+	// Except for the replaced macro, the source is reproduced.
+	assert.Equal(t, strings.Replace(code, "LIMIT\n", "10\n", 1), strings.Join(text, ""))
+}
+
+func TestPreprocessor_indentation(t *testing.T) {
+	l := NewLexer("indent.test", []byte("if x:\n#ifdef A\n    a\n#else\n    b\n#endif\n"))
+	l.SetIndentation(true)
+	var identities []string
+	for _, symbol := range tokensOf(NewPreprocessor(l)) {
+		identities = append(identities, symbol.Identity())
+	}
+	assert.Equal(t, []string{`"if"`, `"x"`, `colon (":")`, "EOL", "INDENT", `"b"`, "EOL", "DEDENT"}, identities)
+}
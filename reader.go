@@ -0,0 +1,99 @@
+package parsing
+
+import "io"
+
+// readerChunkSize is the minimum number of bytes a streaming Lexer will ask
+// its io.Reader for at a time.
+var readerChunkSize = 64 * 1024
+
+// NewReaderLexer constructs a Lexer that consumes source code from an io.Reader
+// in chunks rather than requiring the entire file be held in memory.
+//
+// Offsets (Start, End, LineNo etc) are relative to the start of the stream,
+// just as they are for NewLexer. Value only remains valid until the next
+// call to Advance, use String for a copy that is safe to keep.
+func NewReaderLexer(name string, reader io.Reader) *Lexer {
+	return DefaultDialect.NewReaderLexer(name, reader)
+}
+
+// Retain asks a streaming Lexer to keep source code from offset onwards
+// buffered, even once Advance has moved beyond it, so that it remains
+// available to Slice. Passing a negative offset releases the retention.
+// This is a no-op for lexers created with NewLexer.
+func (l *Lexer) Retain(offset int) {
+	l.retain = offset
+}
+
+// Slice returns the source code between two offsets, provided that it is
+// still buffered. For lexers created with NewLexer this is always true.
+func (l *Lexer) Slice(start, end int) ([]byte, bool) {
+	if start < l.base || start > end || !l.fill(end-1) {
+		return nil, false
+	}
+	return l.code[start-l.base : end-l.base], true
+}
+
+// byteAt returns the byte at a given offset in the source, reading more
+// from the underlying stream if necessary. ok is false at end-of-file.
+func (l *Lexer) byteAt(pos int) (char byte, ok bool) {
+	if idx := pos - l.base; uint(idx) < uint(len(l.code)) {
+		return l.code[idx], true
+	}
+	if l.fill(pos) && pos >= l.base {
+		return l.code[pos-l.base], true
+	}
+	return EOFByte, false
+}
+
+// buffered returns the source from End to the end of the buffer, reading more
+// from the underlying stream if none is buffered. It is empty at end-of-file.
+func (l *Lexer) buffered() []byte {
+	if _, ok := l.byteAt(l.End); !ok {
+		return nil
+	}
+	return l.code[l.End-l.base:]
+}
+
+// fill ensures that the buffer contains the byte at pos, if the source has
+// one. Returns false if the source ends before pos.
+func (l *Lexer) fill(pos int) bool {
+	for pos-l.base >= len(l.code) {
+		if l.reader == nil {
+			return false
+		}
+		if len(l.code) == cap(l.code) {
+			l.compact()
+		}
+		if free := cap(l.code) - len(l.code); free == 0 || free < readerChunkSize/2 {
+			grown := make([]byte, len(l.code), cap(l.code)*2+readerChunkSize)
+			copy(grown, l.code)
+			l.code = grown
+		}
+		n, err := l.reader.Read(l.code[len(l.code):cap(l.code)])
+		l.code = l.code[:len(l.code)+n]
+		if err != nil {
+			l.reader = nil
+			if err != io.EOF {
+				l.Fatal("read error: %s", err)
+			}
+		}
+	}
+	return true
+}
+
+// compact discards the portion of the buffer that precedes the current token,
+// any retained offset and any outstanding marks.
+func (l *Lexer) compact() {
+	keep := l.pinned(l.Start)
+	if l.retain >= 0 && l.retain < keep {
+		keep = l.retain
+	}
+	discard := keep - l.base
+	if discard <= 0 {
+		return
+	}
+	// Line positions must be captured before the text is lost.
+	l.indexLines(keep)
+	l.code = l.code[:copy(l.code, l.code[discard:])]
+	l.base += discard
+}
@@ -0,0 +1,132 @@
+package parsing
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withChunkSize runs action with a reduced streaming chunk size so that tests
+// exercise buffer compaction without needing large inputs.
+func withChunkSize(size int, action func()) {
+	saved := readerChunkSize
+	readerChunkSize = size
+	defer func() { readerChunkSize = saved }()
+	action()
+}
+
+const readerTestCode = "// header comment\nkeyword 'string' 123 4.56\n/* multi\nline */ ident_1 (+7) [-.5]\n\"tail\""
+
+func TestNewReaderLexer(t *testing.T) {
+	lexer := NewReaderLexer("reader.test", strings.NewReader("abc"))
+	require.NotNil(t, lexer)
+	assert.Equal(t, "reader.test", lexer.Filename())
+	assert.Equal(t, 0, lexer.Start)
+	assert.Equal(t, 0, lexer.End)
+	assert.Equal(t, InvalidToken, lexer.Token)
+	assert.NotNil(t, lexer.reader)
+	assert.Equal(t, -1, lexer.retain)
+}
+
+func TestReaderLexer_matchesNewLexer(t *testing.T) {
+	type lexed struct {
+		token      Token
+		value      string
+		start, end int
+		line, char int
+	}
+	lex := func(l *Lexer) (result []lexed) {
+		for l.Advance() {
+			result = append(result, lexed{l.Token, l.String(), l.Start, l.End, l.LineNo(l.Start), l.CharNo(l.Start)})
+		}
+		return result
+	}
+
+	want := lex(NewLexer("memory", []byte(readerTestCode)))
+	require.NotEmpty(t, want)
+
+	for _, size := range []int{1, 2, 7, 64} {
+		withChunkSize(size, func() {
+			got := lex(NewReaderLexer("stream", iotest.OneByteReader(strings.NewReader(readerTestCode))))
+			assert.Equal(t, want, got, "chunk size %d", size)
+		})
+	}
+}
+
+func TestReaderLexer_compacts(t *testing.T) {
+	code := strings.Repeat("word ", 1000)
+	withChunkSize(16, func() {
+		l := NewReaderLexer("compact", strings.NewReader(code))
+		words := 0
+		for l.Advance() {
+			if l.Token == IdentifierToken {
+				words++
+				assert.Equal(t, "word", l.String())
+			}
+		}
+		assert.Equal(t, 1000, words)
+		assert.Less(t, cap(l.code), len(code))
+		assert.Greater(t, l.base, 0)
+	})
+}
+
+func TestLexer_Retain(t *testing.T) {
+	code := strings.Repeat("x ", 100)
+	withChunkSize(8, func() {
+		l := NewReaderLexer("retain", strings.NewReader(code))
+		require.True(t, l.Advance())
+		l.Retain(0)
+		for l.Advance() {
+		}
+		text, ok := l.Slice(0, len(code))
+		if assert.True(t, ok) {
+			assert.Equal(t, code, string(text))
+		}
+
+		// Without retention, earlier text is discarded.
+		l = NewReaderLexer("discard", strings.NewReader(code))
+		for l.Advance() {
+		}
+		_, ok = l.Slice(0, 2)
+		assert.False(t, ok)
+	})
+}
+
+func TestLexer_Slice(t *testing.T) {
+	l := NewLexer("slice", []byte("hello world"))
+	text, ok := l.Slice(6, 11)
+	if assert.True(t, ok) {
+		assert.Equal(t, "world", string(text))
+	}
+	_, ok = l.Slice(6, 12)
+	assert.False(t, ok)
+	_, ok = l.Slice(6, 5)
+	assert.False(t, ok)
+}
+
+func TestReaderLexer_readError(t *testing.T) {
+	reader := iotest.DataErrReader(iotest.TimeoutReader(bytes.NewReader([]byte("abc def"))))
+	l := NewReaderLexer("error.test", reader)
+	assert.PanicsWithError(t, "error.test:1:1: error: read error: "+iotest.ErrTimeout.Error(), func() {
+		for l.Advance() {
+		}
+	})
+}
+
+var readerArrow = NewTerminal("reader-arrow")
+
+func TestReaderLexer_Parser(t *testing.T) {
+	rules := []Rule{{Sequence: []Token{Minus, SymbolToken}, Applies: readerArrow}}
+	withChunkSize(4, func() {
+		p := NewParser(NewReaderLexer("parser", strings.NewReader("alpha - > beta\n gamma->delta")), rules...)
+		var values []string
+		for ; !p.EOF(); p.Next() {
+			values = append(values, p.Current().Identity())
+		}
+		assert.Equal(t, []string{`"alpha"`, `reader-arrow ("- >")`, `"beta"`, `"gamma"`, `reader-arrow ("->")`, `"delta"`}, values)
+	})
+}
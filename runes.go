@@ -0,0 +1,76 @@
+package parsing
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rune classification for lexers running in unicode mode (see Lexer.SetUnicode).
+// ASCII characters are classified through TokenMap as usual, while everything
+// else follows the Unicode Standard Annex #31 default identifier rules:
+//
+//   ID_Start    = L + Nl + Other_ID_Start - Pattern_Syntax - Pattern_White_Space
+//   ID_Continue = ID_Start + Mn + Mc + Nd + Pc + Other_ID_Continue - Pattern_Syntax - Pattern_White_Space
+
+// idStart and idContinue are the additive ranges of ID_Start and ID_Continue.
+var (
+	idStart    = []*unicode.RangeTable{unicode.L, unicode.Nl, unicode.Other_ID_Start}
+	idContinue = []*unicode.RangeTable{unicode.L, unicode.Nl, unicode.Other_ID_Start,
+		unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc, unicode.Other_ID_Continue}
+	idExcluded = []*unicode.RangeTable{unicode.Pattern_Syntax, unicode.Pattern_White_Space}
+)
+
+// IsAlphaRune returns true for any character that may start an identifier:
+// IsAlpha for ASCII, or the UAX #31 ID_Start property otherwise.
+func IsAlphaRune(r rune) bool {
+	if r < utf8.RuneSelf {
+		return IsAlpha(byte(r))
+	}
+	return unicode.In(r, idStart...) && !unicode.In(r, idExcluded...)
+}
+
+// IsIdentifierContinuationRune returns true for any character that may continue
+// an identifier (2nd character+): IsIdentifierContinuation for ASCII, or the
+// UAX #31 ID_Continue property otherwise.
+func IsIdentifierContinuationRune(r rune) bool {
+	if r < utf8.RuneSelf {
+		return IsIdentifierContinuation(byte(r))
+	}
+	return unicode.In(r, idContinue...) && !unicode.In(r, idExcluded...)
+}
+
+// IsNewlineRune returns true for line terminators, including the unicode NEL,
+// LINE SEPARATOR and PARAGRAPH SEPARATOR characters.
+func IsNewlineRune(r rune) bool {
+	if r < utf8.RuneSelf {
+		return TokenMap[r] == NewlineToken
+	}
+	return r == '\u0085' || r == '\u2028' || r == '\u2029'
+}
+
+// IsWhitespaceRune returns true for non-newline whitespace, such as space, tab
+// or NO-BREAK SPACE.
+func IsWhitespaceRune(r rune) bool {
+	if r < utf8.RuneSelf {
+		return TokenMap[r] == WhitespaceToken
+	}
+	return unicode.Is(unicode.White_Space, r) && !IsNewlineRune(r)
+}
+
+// ClassifyRune returns the base Token type for a character, the unicode
+// equivalent of a TokenMap lookup.
+func ClassifyRune(r rune) Token {
+	switch {
+	case r < utf8.RuneSelf:
+		return TokenMap[r]
+	case IsAlphaRune(r):
+		return AlphaToken
+	case IsNewlineRune(r):
+		return NewlineToken
+	case IsWhitespaceRune(r):
+		return WhitespaceToken
+	case unicode.IsPunct(r) || unicode.IsSymbol(r):
+		return SymbolToken
+	}
+	return InvalidToken
+}
@@ -0,0 +1,73 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IsAlphaRune(t *testing.T) {
+	t.Run("true", func(t *testing.T) {
+		for _, r := range []rune{'a', 'Z', 'é', 'ß', 'Ω', 'д', '名', 'ｶ', 'Ⅻ'} {
+			assert.True(t, IsAlphaRune(r), "%q", r)
+		}
+	})
+	t.Run("false", func(t *testing.T) {
+		for _, r := range []rune{'0', '_', '-', ' ', '\u00a0', '٣', '\u0301', '«', '€', '\u2028'} {
+			assert.False(t, IsAlphaRune(r), "%q", r)
+		}
+	})
+}
+
+func Test_IsIdentifierContinuationRune(t *testing.T) {
+	t.Run("true", func(t *testing.T) {
+		for _, r := range []rune{'a', '0', '_', 'é', '名', '٣', '\u0301', '‿'} {
+			assert.True(t, IsIdentifierContinuationRune(r), "%q", r)
+		}
+	})
+	t.Run("false", func(t *testing.T) {
+		for _, r := range []rune{'-', ' ', '\u00a0', '«', '€', '\u2028', '\u200b'} {
+			assert.False(t, IsIdentifierContinuationRune(r), "%q", r)
+		}
+	})
+}
+
+func Test_IsNewlineRune(t *testing.T) {
+	for _, r := range []rune{'\n', '\r', '\u0085', '\u2028', '\u2029'} {
+		assert.True(t, IsNewlineRune(r), "%q", r)
+	}
+	for _, r := range []rune{' ', '\t', '\u00a0', 'a'} {
+		assert.False(t, IsNewlineRune(r), "%q", r)
+	}
+}
+
+func Test_IsWhitespaceRune(t *testing.T) {
+	for _, r := range []rune{' ', '\t', '\u00a0', '\u2003', '\u3000'} {
+		assert.True(t, IsWhitespaceRune(r), "%q", r)
+	}
+	for _, r := range []rune{'\n', '\u2028', '\u0085', 'a', '\u200b'} {
+		assert.False(t, IsWhitespaceRune(r), "%q", r)
+	}
+}
+
+func Test_ClassifyRune(t *testing.T) {
+	tests := []struct {
+		r    rune
+		want Token
+	}{
+		{'a', AlphaToken},
+		{'{', OpenBrace},
+		{'é', AlphaToken},
+		{'\u00a0', WhitespaceToken},
+		{'\u2029', NewlineToken},
+		{'«', SymbolToken},
+		{'€', SymbolToken},
+		{'٣', InvalidToken},
+		{'\u200b', InvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.r), func(t *testing.T) {
+			assert.Equal(t, tt.want, ClassifyRune(tt.r))
+		})
+	}
+}
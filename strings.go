@@ -0,0 +1,225 @@
+package parsing
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// StringSyntax is a set of flags describing which string literal forms a
+// Dialect accepts in addition to single-line, single- or double-quoted strings.
+type StringSyntax uint
+
+const (
+	// RawStrings accepts `backtick` strings, which may span multiple lines
+	// and have no escape sequences.
+	RawStrings StringSyntax = 1 << iota
+	// TripleQuotedStrings accepts """triple-quoted""" strings, which may
+	// span multiple lines.
+	TripleQuotedStrings
+	// CharLiterals lexes single-quoted literals as a CharToken, which must
+	// contain exactly one character.
+	CharLiterals
+	// ValidEscapes reports invalid escape sequences in strings as lexer
+	// errors, see Symbol.Unquote.
+	ValidEscapes
+)
+
+// SetStringSyntax determines which string literal forms the dialect accepts.
+// Enabling RawStrings makes '`' a StringToken in the dialect's character map.
+func (d *Dialect) SetStringSyntax(syntax StringSyntax) {
+	d.strings = syntax
+	if syntax&RawStrings != 0 {
+		d.charMap['`'] = StringToken
+	}
+	d.invalidate()
+}
+
+// symbolizeString handles any of the dialect's string literal forms,
+// with a fallback to SymbolizeString.
+func (l *Lexer) symbolizeString(dialect *Dialect) {
+	quote, _ := l.byteAt(l.Start)
+	triple := ""
+	if dialect.strings&TripleQuotedStrings != 0 {
+		triple = strings.Repeat(string(quote), 3)
+	}
+	isTriple := triple != "" && l.hasPrefix(l.Start, triple)
+	switch {
+	case quote == '`' && dialect.strings&RawStrings != 0:
+		l.symbolizeDelimited("`", false)
+		return
+
+	case isTriple:
+		l.End = l.Start + len(triple)
+		l.symbolizeDelimited(triple, true)
+
+	default:
+		l.SymbolizeString()
+	}
+	if l.Token != StringToken {
+		return
+	}
+
+	if dialect.strings&(ValidEscapes|CharLiterals) != 0 {
+		value, err := unquote(string(l.Value()))
+		if err != nil {
+			l.fatalAt(l.Start+err.Offset, l.Start+err.Offset+err.Length, "%s", err.Message)
+			return
+		}
+		if quote == '\'' && dialect.strings&CharLiterals != 0 && !isTriple {
+			if utf8.RuneCountInString(value) != 1 {
+				l.Fatal("character literal must contain exactly one character")
+				return
+			}
+			l.Token = CharToken
+		}
+	}
+}
+
+// symbolizeDelimited consumes everything up to and including close, which
+// may span multiple lines. Backslashes escape the following character if
+// escapes is true.
+func (l *Lexer) symbolizeDelimited(close string, escapes bool) {
+	for !l.hasPrefix(l.End, close) {
+		char, ok := l.Read()
+		if !ok {
+			l.Fatal("unterminated string/missing close-quote?")
+			return
+		}
+		if char == '\\' && escapes {
+			l.Read()
+		}
+	}
+	l.End += len(close)
+	l.Token = StringToken
+}
+
+// EscapeError describes an invalid escape sequence in a string literal.
+type EscapeError struct {
+	// Offset is the byte-offset of the escape sequence; relative to the start
+	// of the string for unquote, or the start of the file for Symbol.Unquote.
+	Offset int
+	// Length is the number of bytes in the escape sequence.
+	Length int
+	// Message describes the problem.
+	Message string
+}
+
+func (e *EscapeError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Message)
+}
+
+// Unquote returns the value of a string or character literal Symbol with its
+// quotes removed and escape sequences decoded. Raw (`backtick`) strings are
+// returned verbatim. The Offset of an EscapeError is relative to the source.
+func (s *Symbol) Unquote() (string, error) {
+	value, err := unquote(s.Value)
+	if err != nil {
+		err.Offset += s.StartOffset
+		return "", err
+	}
+	return value, nil
+}
+
+// simpleEscapes maps single-character escape sequences to their values.
+var simpleEscapes = [256]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	'\\': '\\', '\'': '\'', '"': '"',
+}
+
+// unquote strips the quotes from a literal and decodes its escape sequences.
+func unquote(literal string) (string, *EscapeError) {
+	quotes := 1
+	switch {
+	case len(literal) >= 2 && literal[0] == '`' && literal[len(literal)-1] == '`':
+		return literal[1 : len(literal)-1], nil
+	case len(literal) >= 6 && (strings.HasPrefix(literal, `"""`) || strings.HasPrefix(literal, `'''`)) &&
+		strings.HasSuffix(literal, literal[:3]):
+		quotes = 3
+	case len(literal) >= 2 && (literal[0] == '"' || literal[0] == '\'') && literal[len(literal)-1] == literal[0]:
+	default:
+		return "", &EscapeError{0, len(literal), "not a quoted string"}
+	}
+	body := literal[quotes : len(literal)-quotes]
+	if strings.IndexByte(body, '\\') < 0 {
+		return body, nil
+	}
+
+	var value strings.Builder
+	value.Grow(len(body))
+	for idx := 0; idx < len(body); {
+		if body[idx] != '\\' {
+			value.WriteByte(body[idx])
+			idx++
+			continue
+		}
+		offset := quotes + idx
+		if idx+1 >= len(body) {
+			return "", &EscapeError{offset, 1, "incomplete escape sequence"}
+		}
+		code := body[idx+1]
+		if decoded := simpleEscapes[code]; decoded != 0 {
+			value.WriteByte(decoded)
+			idx += 2
+			continue
+		}
+
+		var digits, radix int
+		switch code {
+		case 'x':
+			digits, radix = 2, 16
+		case 'u':
+			digits, radix = 4, 16
+		case 'U':
+			digits, radix = 8, 16
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			digits, radix = 3, 8
+		default:
+			return "", &EscapeError{offset, 2, fmt.Sprintf("unknown escape sequence \\%c", code)}
+		}
+
+		start := idx + 2
+		if radix == 8 {
+			// octal sequences don't have a prefix character.
+			start = idx + 1
+		}
+		end := start + digits
+		if end > len(body) {
+			return "", &EscapeError{offset, len(body) - idx, "incomplete escape sequence"}
+		}
+		var number rune
+		for _, digit := range []byte(body[start:end]) {
+			nibble := hexValue(digit)
+			if nibble < 0 || nibble >= radix {
+				return "", &EscapeError{offset, end - idx, fmt.Sprintf("invalid escape sequence %s", body[idx:end])}
+			}
+			number = number*rune(radix) + rune(nibble)
+		}
+		switch {
+		case code == 'x' || radix == 8:
+			if number > 255 {
+				return "", &EscapeError{offset, end - idx, fmt.Sprintf("escape sequence %s is out of range", body[idx:end])}
+			}
+			value.WriteByte(byte(number))
+		case !utf8.ValidRune(number):
+			return "", &EscapeError{offset, end - idx, fmt.Sprintf("escape sequence %s is not a valid unicode code point", body[idx:end])}
+		default:
+			value.WriteRune(number)
+		}
+		idx = end
+	}
+	return value.String(), nil
+}
+
+// hexValue returns the value of a hexadecimal digit, or -1.
+func hexValue(digit byte) int {
+	switch {
+	case digit >= '0' && digit <= '9':
+		return int(digit - '0')
+	case digit >= 'a' && digit <= 'f':
+		return int(digit-'a') + 10
+	case digit >= 'A' && digit <= 'F':
+		return int(digit-'A') + 10
+	}
+	return -1
+}
@@ -0,0 +1,153 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialect_SetStringSyntax(t *testing.T) {
+	d := NewDialect("strings")
+	assert.Equal(t, InvalidToken, d.Class('`'))
+	d.SetStringSyntax(RawStrings | TripleQuotedStrings | CharLiterals)
+	assert.Equal(t, StringToken, d.Class('`'))
+
+	tests := []struct {
+		name, code string
+		token      Token
+		want       string
+	}{
+		{"double", `"a\"b" x`, StringToken, `"a\"b"`},
+		{"raw", "`a\\n\nb` x", StringToken, "`a\\n\nb`"},
+		{"triple", "\"\"\"a\n\"b\"\n\\\"\"\"\" x", StringToken, "\"\"\"a\n\"b\"\n\\\"\"\"\""},
+		{"triple single", "'''it's\n''' x", StringToken, "'''it's\n'''"},
+		{"empty", `"" x`, StringToken, `""`},
+		{"char", `'a' x`, CharToken, `'a'`},
+		{"char escape", `'\n' x`, CharToken, `'\n'`},
+		{"char unicode", `'名' x`, CharToken, `'名'`},
+		{"char code point", `'名' x`, CharToken, `'名'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := d.NewLexer("strings.test", []byte(tt.code))
+			if assert.True(t, l.Advance()) && assert.Equal(t, tt.token, l.Token) {
+				assert.Equal(t, tt.want, l.String())
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		_, values := lexTokens(NewLexer("disabled.test", []byte(`"""a""" 'ab'`)))
+		assert.Equal(t, []string{`""`, `"a"`, `""`, `'ab'`}, values)
+	})
+}
+
+func TestDialect_SetStringSyntax_errors(t *testing.T) {
+	d := NewDialect("strings")
+	d.SetStringSyntax(RawStrings | TripleQuotedStrings | CharLiterals | ValidEscapes)
+	tests := []struct {
+		name, code, err string
+	}{
+		{"raw", "x `abc\n", "errors.test:1:3-2:1: error: unterminated string/missing close-quote?"},
+		{"triple", "\"\"\"abc\"\"", "errors.test:1:1-1:9: error: unterminated string/missing close-quote?"},
+		{"empty char", "''", "errors.test:1:1-1:3: error: character literal must contain exactly one character"},
+		{"long char", "'ab'", "errors.test:1:1-1:5: error: character literal must contain exactly one character"},
+		{"bad escape", `"abc\qdef"`, "errors.test:1:5-1:7: error: unknown escape sequence \\q"},
+		{"bad hex", `"\x4g"`, "errors.test:1:2-1:6: error: invalid escape sequence \\x4g"},
+		{"bad char escape", `'\z'`, "errors.test:1:2-1:4: error: unknown escape sequence \\z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := d.NewLexer("errors.test", []byte(tt.code))
+			assert.PanicsWithError(t, tt.err, func() { lexTokens(l) })
+		})
+	}
+}
+
+func Test_unquote(t *testing.T) {
+	tests := []struct {
+		literal, want string
+	}{
+		{`""`, ""},
+		{`"abc"`, "abc"},
+		{`'abc'`, "abc"},
+		{"`a\\nb`", "a\\nb"},
+		{`"""a"b"""`, `a"b`},
+		{`'''a\tb'''`, "a\tb"},
+		{`"\a\b\f\n\r\t\v\\\'\""`, "\a\b\f\n\r\t\v\\'\""},
+		{`"\x41\x7a"`, "Az"},
+		{`"\xff"`, "\xff"},
+		{`"\101"`, "A"},
+		{`"名前"`, "名前"},
+		{`"\U0001F600"`, "\U0001F600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.literal, func(t *testing.T) {
+			got, err := unquote(tt.literal)
+			if assert.Nil(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_unquote_errors(t *testing.T) {
+	tests := []struct {
+		literal        string
+		offset, length int
+		message        string
+	}{
+		{`abc`, 0, 3, "not a quoted string"},
+		{`"abc'`, 0, 5, "not a quoted string"},
+		{`"`, 0, 1, "not a quoted string"},
+		{`"\"`, 1, 1, "incomplete escape sequence"},
+		{`"abc\"`, 4, 1, "incomplete escape sequence"},
+		{`"a\q"`, 2, 2, `unknown escape sequence \q`},
+		{`"a\x4"`, 2, 3, "incomplete escape sequence"},
+		{`"a\xag"`, 2, 4, `invalid escape sequence \xag`},
+		{`"\u12"`, 1, 4, "incomplete escape sequence"},
+		{`"\ud800"`, 1, 6, `escape sequence \ud800 is not a valid unicode code point`},
+		{`"\U00110000"`, 1, 10, `escape sequence \U00110000 is not a valid unicode code point`},
+		{`"\400"`, 1, 4, `escape sequence \400 is out of range`},
+		{`"\18x"`, 1, 4, `invalid escape sequence \18x`},
+		{`"\18"`, 1, 3, "incomplete escape sequence"},
+		{`"""a\q"""`, 4, 2, `unknown escape sequence \q`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.literal, func(t *testing.T) {
+			_, err := unquote(tt.literal)
+			if assert.NotNil(t, err) {
+				assert.Equal(t, &EscapeError{tt.offset, tt.length, tt.message}, err)
+			}
+		})
+	}
+}
+
+func TestSymbol_Unquote(t *testing.T) {
+	symbol := &Symbol{Token: StringToken, Value: `"a\nb"`, StartOffset: 10, EndOffset: 16}
+	value, err := symbol.Unquote()
+	if assert.Nil(t, err) {
+		assert.Equal(t, "a\nb", value)
+	}
+
+	symbol.Value = `"a\qb"`
+	_, err = symbol.Unquote()
+	if assert.Error(t, err) {
+		assert.Equal(t, &EscapeError{12, 2, `unknown escape sequence \q`}, err)
+		assert.Equal(t, `offset 12: unknown escape sequence \q`, err.Error())
+	}
+}
+
+func TestParser_Unquote(t *testing.T) {
+	p := NewParser(NewLexer("unquote.test", []byte("x\n  'ab\\wc' 'ok\\t'")))
+	p.Next()
+	_, err := p.Unquote(p.Current())
+	if assert.Error(t, err) {
+		assert.Equal(t, `unquote.test:2:6: unknown escape sequence \w`, err.Error())
+	}
+	p.Next()
+	value, err := p.Unquote(p.Current())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ok\t", value)
+	}
+}
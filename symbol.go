@@ -15,6 +15,20 @@ type Symbol struct {
 	StartOffset int
 	// EndOffset is the byte-count to the last character of the Symbol.
 	EndOffset int
+	// Pos is the position of the Symbol in the lexer's FileSet, or NoPos if
+	// it doesn't have one, see Lexer.SetFileSet.
+	Pos Pos
+	// Leading and Trailing are the trivia either side of the Symbol, see Text.
+	Leading, Trailing []Symbol
+
+	mode   *Dialect    // lexer mode, if other than the lexer's Dialect
+	origin *sourceFile // included file, if other than the lexer's main file
+}
+
+// symbol returns a Symbol describing the lexer's current token, which began
+// in mode (see Lexer.currentMode).
+func (l *Lexer) symbol(mode *Dialect) Symbol {
+	return Symbol{Token: l.Token, Value: l.value(), StartOffset: l.Start, EndOffset: l.End, Pos: l.pos(l.Start), mode: mode, origin: l.file}
 }
 
 // Equals will test if a symbol represents a particular Token.
@@ -30,9 +44,9 @@ func (s *Symbol) Identity() string {
 	}
 	if !s.Token.IsTerminal() {
 		switch s.Token {
-		case InvalidToken, EOFToken, WhitespaceToken, NewlineToken, CommentToken:
+		case InvalidToken, ErrorToken, EOFToken, WhitespaceToken, NewlineToken, CommentToken, EOLToken, DirectiveToken, SkippedToken:
 			return *s.Token.string
-		case AlphaToken, DigitToken, SymbolToken, IntegerToken, FloatToken:
+		case AlphaToken, DigitToken, SymbolToken, IntegerToken, FloatToken, CharToken:
 			return fmt.Sprintf("%s %q", *s.Token.string, s.String())
 		case IdentifierToken, StringToken:
 			return fmt.Sprintf("%q", s.String())
@@ -10,7 +10,7 @@ func TestSymbol_Equals(t *testing.T) {
 	sym := &Symbol{Token: EOFToken}
 	if assert.False(t, sym.Equals(Token{})) {
 		assert.True(t, sym.Equals(EOFToken))
-		assert.False(t, sym.Equals(NewToken("EOF")))
+		assert.False(t, sym.Equals(unregisteredToken("EOF")))
 	}
 }
 
@@ -29,6 +29,7 @@ func TestSymbol_Identity(t *testing.T) {
 		want  string
 	}{
 		{InvalidToken, "asdf", `INVALID`},
+		{ErrorToken, "'ab", `ERROR`},
 		{EOFToken, "", `EOF`},
 		{EOFToken, "sdfg", `EOF`},
 		{WhitespaceToken, "dfgh", `WHITESPACE`},
@@ -1,5 +1,11 @@
 package parsing
 
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
 // Token identifies a significant pattern in a code stream, from a specific
 // keyword to an integer to whitespace to end-of-file. Here, Token is a
 // pointer to a friendly name for the Token. Using a pointer allows for
@@ -15,12 +21,14 @@ func (t Token) IsTerminal() bool {
 }
 
 // NewToken will return a new Token with the friendly name given, with
-// an all uppercase name.
+// an all uppercase name. If a Token with the name already exists, it is
+// returned instead, see RegisterToken.
 func NewToken(label string) Token {
 	if label[0] < 'A' || label[0] > 'Z' {
 		panic(label + ": tokens must begin with a capital letter")
 	}
-	return Token{&label}
+	token, _ := registerToken(label)
+	return token
 }
 
 func (t Token) String() string {
@@ -29,12 +37,81 @@ func (t Token) String() string {
 
 // Terminals represent an explicit character match and start with a lowercase character.
 
-// NewTerminal will return a new Token with a friendly, lowercase name.
+// NewTerminal will return a new Token with a friendly, lowercase name. If a
+// Terminal with the name already exists, it is returned instead.
 func NewTerminal(label string) Token {
 	if label[0] < 'a' || label[0] > 'z' {
 		panic(label + ": terminals must begin with a lowercase letter")
 	}
-	return Token{&label}
+	token, _ := registerToken(label)
+	return token
+}
+
+// RegisterToken returns a new Token, or a Terminal if the name begins with a
+// lowercase letter. Unlike NewToken and NewTerminal, it returns an error if
+// the name is already in use, e.g. when tokens are declared by a grammar.
+func RegisterToken(name string) (Token, error) {
+	if name == "" || !(name[0] >= 'A' && name[0] <= 'Z' || name[0] >= 'a' && name[0] <= 'z') {
+		return Token{}, fmt.Errorf("%q: tokens must begin with a letter", name)
+	}
+	token, created := registerToken(name)
+	if !created {
+		return token, fmt.Errorf("%s: a token with this name already exists", name)
+	}
+	return token, nil
+}
+
+// tokenRegistry records every Token and Terminal by name, so that names are
+// unique and tokens can be found by name, e.g. when reading a grammar or a
+// serialized token stream.
+var tokenRegistry = struct {
+	sync.RWMutex
+	tokens map[string]Token
+}{tokens: make(map[string]Token)}
+
+// registerToken returns the Token with a name, creating it if the name isn't
+// yet in use.
+func registerToken(label string) (token Token, created bool) {
+	tokenRegistry.Lock()
+	defer tokenRegistry.Unlock()
+	if token, exists := tokenRegistry.tokens[label]; exists {
+		return token, false
+	}
+	token = Token{&label}
+	tokenRegistry.tokens[label] = token
+	return token, true
+}
+
+// LookupToken returns the Token or Terminal with the given name.
+func LookupToken(name string) (Token, bool) {
+	tokenRegistry.RLock()
+	defer tokenRegistry.RUnlock()
+	token, ok := tokenRegistry.tokens[name]
+	return token, ok
+}
+
+// Tokens returns every Token that isn't a Terminal, ordered by name.
+func Tokens() []Token {
+	return registeredTokens(false)
+}
+
+// Terminals returns every Terminal, ordered by name.
+func Terminals() []Token {
+	return registeredTokens(true)
+}
+
+// registeredTokens returns the Terminals, or the other Tokens, ordered by name.
+func registeredTokens(terminals bool) []Token {
+	tokenRegistry.RLock()
+	tokens := make([]Token, 0, len(tokenRegistry.tokens))
+	for _, token := range tokenRegistry.tokens {
+		if token.IsTerminal() == terminals {
+			tokens = append(tokens, token)
+		}
+	}
+	tokenRegistry.RUnlock()
+	sort.Slice(tokens, func(i, j int) bool { return *tokens[i].string < *tokens[j].string })
+	return tokens
 }
 
 // SomethingToken denotes a class of token rather a match to a single
@@ -43,6 +120,7 @@ func NewTerminal(label string) Token {
 var (
 	// Noise
 	InvalidToken    = NewToken("INVALID")
+	ErrorToken      = NewToken("ERROR")
 	EOFToken        = NewToken("EOF")
 	WhitespaceToken = NewToken("WHITESPACE")
 	NewlineToken    = NewToken("NEWLINE")
@@ -55,11 +133,21 @@ var (
 
 	// Literals
 	StringToken  = NewToken("STRING")
+	CharToken    = NewToken("CHAR")
 	IntegerToken = NewToken("INTEGER")
 	FloatToken   = NewToken("FLOAT")
 
 	// Identifiers
 	IdentifierToken = NewToken("IDENTIFIER")
+
+	// Indentation, see Lexer.SetIndentation
+	IndentToken = NewToken("INDENT")
+	DedentToken = NewToken("DEDENT")
+	EOLToken    = NewToken("EOL")
+
+	// Preprocessing, see Preprocessor
+	DirectiveToken = NewToken("DIRECTIVE")
+	SkippedToken   = NewToken("SKIPPED")
 )
 
 // Tokens resolving to a single literal value.
@@ -85,5 +173,9 @@ var (
 )
 
 func IsSignificant(token Token) bool {
-	return token != WhitespaceToken && token != NewlineToken && token != CommentToken
+	switch token {
+	case WhitespaceToken, NewlineToken, CommentToken, DirectiveToken, SkippedToken:
+		return false
+	}
+	return true
 }
@@ -1,14 +1,24 @@
 package parsing
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// unregisteredToken returns a Token outside the registry, for tests that need
+// a token distinct from a registered one of the same name.
+func unregisteredToken(name string) Token {
+	return Token{&name}
+}
+
 func TestNewToken(t *testing.T) {
 	assert.Panics(t, func() { NewToken("abc") })
 	assert.Equal(t, "X123abc", *NewToken("X123abc").string)
+	// The name is registered once.
+	assert.Equal(t, NewToken("X123abc"), NewToken("X123abc"))
+	assert.Equal(t, EOFToken, NewToken("EOF"))
 }
 
 func TestToken_String(t *testing.T) {
@@ -19,6 +29,34 @@ func TestToken_String(t *testing.T) {
 func TestNewTerminal(t *testing.T) {
 	assert.Panics(t, func() { NewTerminal("ABC") })
 	assert.Equal(t, "x123ABC", *NewTerminal("x123ABC").string)
+	assert.Equal(t, OpenBrace, NewTerminal("open-brace"))
+}
+
+func TestRegisterToken(t *testing.T) {
+	// Fresh names for each run of the test.
+	for _, name := range []string{"REGISTERED" + strconv.Itoa(len(Tokens())), "registered" + strconv.Itoa(len(Terminals()))} {
+		token, err := RegisterToken(name)
+		if assert.NoError(t, err) {
+			assert.Equal(t, name, token.String())
+			assert.Equal(t, name[0] == 'r', token.IsTerminal())
+		}
+		again, err := RegisterToken(name)
+		assert.EqualError(t, err, name+": a token with this name already exists")
+		assert.Equal(t, token, again)
+	}
+
+	tests := []struct {
+		name, err string
+	}{
+		{"EOF", "EOF: a token with this name already exists"},
+		{"open-brace", "open-brace: a token with this name already exists"},
+		{"1st", `"1st": tokens must begin with a letter`},
+		{"", `"": tokens must begin with a letter`},
+	}
+	for _, tt := range tests {
+		_, err := RegisterToken(tt.name)
+		assert.EqualError(t, err, tt.err)
+	}
 }
 
 func TestToken_IsTerminal(t *testing.T) {
@@ -28,6 +66,44 @@ func TestToken_IsTerminal(t *testing.T) {
 	}
 }
 
+func TestLookupToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token Token
+		ok    bool
+	}{
+		{"EOF", EOFToken, true},
+		{"open-brace", OpenBrace, true},
+		{"Yadda", NewToken("Yadda"), true},
+		{"yadda", NewTerminal("yadda"), true},
+		{"YADDA", Token{}, false},
+		{"", Token{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, ok := LookupToken(tt.name)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.token, token)
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	token, terminal := NewToken("Yadda"), NewTerminal("yadda")
+	tokens, terminals := Tokens(), Terminals()
+	assert.Contains(t, tokens, EOFToken)
+	assert.Contains(t, tokens, token)
+	assert.NotContains(t, tokens, OpenBrace)
+	assert.Contains(t, terminals, OpenBrace)
+	assert.Contains(t, terminals, terminal)
+	assert.NotContains(t, terminals, EOFToken)
+	for _, list := range [][]Token{tokens, terminals} {
+		for idx := 1; idx < len(list); idx++ {
+			assert.Less(t, list[idx-1].String(), list[idx].String())
+		}
+	}
+}
+
 func TestIsSignificant(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -37,7 +113,7 @@ func TestIsSignificant(t *testing.T) {
 		{"space", WhitespaceToken, false},
 		{"newline", NewlineToken, false},
 		{"comment", CommentToken, false},
-		{"<new whitespace>", NewToken("WHITESPACE"), true},
+		{"<new whitespace>", unregisteredToken("WHITESPACE"), true},
 		{"EOF", EOFToken, true},
 		{"Invalid", InvalidToken, true},
 		{"String", StringToken, true},
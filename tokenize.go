@@ -0,0 +1,187 @@
+package parsing
+
+import "sort"
+
+// TokenList is the complete sequence of Symbols lexed from a source, in order
+// of their offsets, see Tokenize.
+type TokenList []Symbol
+
+// Tokenize lexes the whole of a source and returns its symbols. Trivia, which
+// is whitespace, newlines and comments (see IsSignificant), is only included
+// if trivia is true. The EOF token is not included.
+//
+// If the lexer encounters an error, Tokenize returns the symbols lexed up to
+// that point and the *LexError. In recovery mode (see Lexer.SetRecovery),
+// lexing continues to the end of the source and any errors are returned as
+// LexErrors, with ErrorToken symbols in their place.
+func Tokenize(l *Lexer, trivia bool) (tokens TokenList, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			lexErr, ok := r.(*LexError)
+			if !ok {
+				panic(r)
+			}
+			err = lexErr
+		}
+	}()
+	for {
+		mode := l.currentMode()
+		if !l.Advance() {
+			break
+		}
+		if trivia || IsSignificant(l.Token) {
+			tokens = append(tokens, l.symbol(mode))
+		}
+	}
+	return tokens, l.Err()
+}
+
+// Search returns the index of the first symbol that ends after offset, or
+// len(tokens) if there is none.
+func (tokens TokenList) Search(offset int) int {
+	return sort.Search(len(tokens), func(idx int) bool {
+		return tokens[idx].EndOffset > offset
+	})
+}
+
+// SymbolAt returns the symbol that contains the byte at offset, or nil if
+// there isn't one, e.g. because the offset is in trivia that was filtered.
+func (tokens TokenList) SymbolAt(offset int) *Symbol {
+	if idx := tokens.Search(offset); idx < len(tokens) && tokens[idx].StartOffset <= offset {
+		return &tokens[idx]
+	}
+	return nil
+}
+
+// Range returns the symbols that overlap the byte span [start, end). The
+// result shares storage with tokens.
+func (tokens TokenList) Range(start, end int) TokenList {
+	first := tokens.Search(start)
+	last := first
+	for last < len(tokens) && tokens[last].StartOffset < end {
+		last++
+	}
+	return tokens[first:last]
+}
+
+// NextSignificant returns the index of the first significant symbol after
+// index idx, or -1 if there is none. Pass -1 to search from the beginning.
+func (tokens TokenList) NextSignificant(idx int) int {
+	for idx++; idx < len(tokens); idx++ {
+		if IsSignificant(tokens[idx].Token) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// PrevSignificant returns the index of the last significant symbol before
+// index idx, or -1 if there is none. Pass len(tokens) to search from the end.
+func (tokens TokenList) PrevSignificant(idx int) int {
+	for idx--; idx >= 0; idx-- {
+		if IsSignificant(tokens[idx].Token) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// Edit describes a change to a source: the bytes from Start up to End are
+// replaced by Text.
+type Edit struct {
+	Start, End int
+	Text       []byte
+}
+
+// Apply returns a copy of code with the edit made.
+func (e Edit) Apply(code []byte) []byte {
+	edited := make([]byte, 0, len(code)-(e.End-e.Start)+len(e.Text))
+	return append(append(append(edited, code[:e.Start]...), e.Text...), code[e.End:]...)
+}
+
+// Delta returns the change in the length of the source made by the edit.
+func (e Edit) Delta() int { return len(e.Text) - (e.End - e.Start) }
+
+// Relex returns the symbols of a source after an edit, given the symbols
+// Tokenize returned for it before the edit, with the same value of trivia.
+// l must be a new lexer for the edited source, configured like the one that
+// produced tokens.
+//
+// Only the region around the edit is lexed: lexing restarts at the token
+// before the edit, and stops at the first token after the edit that begins
+// at the same position, in the same mode, as a token in tokens, which is
+// then reused with the rest of the list, their offsets shifted. tokens is not
+// modified. Sources in indentation mode, with includes enabled or whose
+// dialect has line directives are tokenized from the start.
+func (tokens TokenList) Relex(l *Lexer, edit Edit, trivia bool) (relexed TokenList, err error) {
+	if l.indent != nil || l.includeOptions != nil || len(l.Dialect().lineDirectives) > 0 {
+		return Tokenize(l, trivia)
+	}
+
+	// The token that ends at the edit could be extended by it, and its end
+	// may depend on the character after it, so restart from the one before,
+	// or earlier if that began in a mode.
+	restart := tokens.Search(edit.Start-1) - 1
+	for restart > 0 && tokens[restart].mode != nil {
+		restart--
+	}
+	offset := 0
+	if restart > 0 {
+		offset = tokens[restart].StartOffset
+	} else {
+		restart = 0
+	}
+	relexed = append(relexed, tokens[:restart]...)
+	l.Start, l.End = offset, offset
+
+	defer func() {
+		if r := recover(); r != nil {
+			lexErr, ok := r.(*LexError)
+			if !ok {
+				panic(r)
+			}
+			err = lexErr
+		}
+	}()
+	delta, editEnd, old := edit.Delta(), edit.Start+len(edit.Text), restart
+	for {
+		mode := l.currentMode()
+		if !l.Advance() {
+			break
+		}
+		if l.Start >= editEnd {
+			// Lexing from here would repeat the original tokens.
+			for old < len(tokens) && tokens[old].StartOffset < l.Start-delta {
+				old++
+			}
+			if old < len(tokens) && tokens[old].StartOffset == l.Start-delta && tokens[old].mode == mode {
+				for _, symbol := range tokens[old:] {
+					relexed = append(relexed, symbol.shifted(delta, l))
+				}
+				break
+			}
+		}
+		if trivia || IsSignificant(l.Token) {
+			relexed = append(relexed, l.symbol(mode))
+		}
+	}
+	return relexed, l.Err()
+}
+
+// shifted returns a copy of the symbol, and its trivia, moved by delta bytes
+// within the source of l.
+func (s Symbol) shifted(delta int, l *Lexer) Symbol {
+	s.StartOffset += delta
+	s.EndOffset += delta
+	s.Pos = l.pos(s.StartOffset)
+	for _, trivia := range []*[]Symbol{&s.Leading, &s.Trailing} {
+		if len(*trivia) > 0 {
+			moved := make([]Symbol, len(*trivia))
+			for idx, symbol := range *trivia {
+				moved[idx] = symbol.shifted(delta, l)
+			}
+			*trivia = moved
+		}
+	}
+	return s
+}
@@ -0,0 +1,241 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tokenizeCode = "a = 1 // one\nb(2)"
+
+func TestTokenize(t *testing.T) {
+	t.Run("significant", func(t *testing.T) {
+		tokens, err := Tokenize(NewLexer("tokenize.test", []byte(tokenizeCode)), false)
+		require.NoError(t, err)
+		assert.Equal(t, TokenList{
+			{Token: IdentifierToken, Value: "a", StartOffset: 0, EndOffset: 1},
+			{Token: Equals, Value: "=", StartOffset: 2, EndOffset: 3},
+			{Token: IntegerToken, Value: "1", StartOffset: 4, EndOffset: 5},
+			{Token: IdentifierToken, Value: "b", StartOffset: 13, EndOffset: 14},
+			{Token: OpenParen, Value: "(", StartOffset: 14, EndOffset: 15},
+			{Token: IntegerToken, Value: "2", StartOffset: 15, EndOffset: 16},
+			{Token: CloseParen, Value: ")", StartOffset: 16, EndOffset: 17},
+		}, tokens)
+	})
+
+	t.Run("trivia", func(t *testing.T) {
+		tokens, err := Tokenize(NewLexer("tokenize.test", []byte(tokenizeCode)), true)
+		require.NoError(t, err)
+		var text string
+		var kinds []Token
+		for _, symbol := range tokens {
+			text += symbol.Value
+			kinds = append(kinds, symbol.Token)
+		}
+		assert.Equal(t, tokenizeCode, text)
+		assert.Equal(t, []Token{IdentifierToken, WhitespaceToken, Equals, WhitespaceToken, IntegerToken, WhitespaceToken,
+			CommentToken, NewlineToken, IdentifierToken, OpenParen, IntegerToken, CloseParen}, kinds)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		tokens, err := Tokenize(NewLexer("empty.test", nil), true)
+		assert.NoError(t, err)
+		assert.Empty(t, tokens)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		tokens, err := Tokenize(NewLexer("error.test", []byte("a 'b")), false)
+		assert.EqualError(t, err, "error.test:1:3-1:5: error: unterminated string/missing close-quote?")
+		assert.Len(t, tokens, 1)
+	})
+
+	t.Run("recovery", func(t *testing.T) {
+		l := NewLexer("recovery.test", []byte("a 'b\nc \"d"))
+		l.SetRecovery(true)
+		tokens, err := Tokenize(l, false)
+		if assert.Error(t, err) {
+			assert.IsType(t, LexErrors{}, err)
+			assert.Len(t, err.(LexErrors), 2)
+		}
+		var kinds []Token
+		for _, symbol := range tokens {
+			kinds = append(kinds, symbol.Token)
+		}
+		assert.Equal(t, []Token{IdentifierToken, ErrorToken, IdentifierToken, ErrorToken}, kinds)
+	})
+}
+
+func TestTokenList_SymbolAt(t *testing.T) {
+	tokens, err := Tokenize(NewLexer("tokenize.test", []byte(tokenizeCode)), false)
+	require.NoError(t, err)
+	tests := []struct {
+		offset int
+		want   string
+	}{
+		{-1, ""}, {0, "a"}, {1, ""}, {2, "="}, {4, "1"}, {7, ""}, {13, "b"}, {16, ")"}, {17, ""},
+	}
+	for _, tt := range tests {
+		symbol := tokens.SymbolAt(tt.offset)
+		if tt.want == "" {
+			assert.Nil(t, symbol, "offset %d", tt.offset)
+		} else if assert.NotNil(t, symbol, "offset %d", tt.offset) {
+			assert.Equal(t, tt.want, symbol.Value)
+		}
+	}
+
+	all, _ := Tokenize(NewLexer("tokenize.test", []byte(tokenizeCode)), true)
+	if symbol := all.SymbolAt(7); assert.NotNil(t, symbol) {
+		assert.Equal(t, CommentToken, symbol.Token)
+	}
+	assert.Nil(t, TokenList(nil).SymbolAt(0))
+}
+
+func TestTokenList_Range(t *testing.T) {
+	tokens, _ := Tokenize(NewLexer("tokenize.test", []byte(tokenizeCode)), false)
+	values := func(list TokenList) (result []string) {
+		for _, symbol := range list {
+			result = append(result, symbol.Value)
+		}
+		return
+	}
+	assert.Equal(t, []string{"a", "=", "1"}, values(tokens.Range(0, 5)))
+	assert.Equal(t, []string{"=", "1"}, values(tokens.Range(1, 5)))
+	assert.Equal(t, []string{"1", "b"}, values(tokens.Range(4, 14)))
+	assert.Equal(t, []string{"b", "(", "2", ")"}, values(tokens.Range(6, 100)))
+	assert.Empty(t, tokens.Range(6, 13))
+	assert.Empty(t, tokens.Range(17, 20))
+}
+
+func TestTokenList_NextSignificant(t *testing.T) {
+	tokens, _ := Tokenize(NewLexer("tokenize.test", []byte(tokenizeCode)), true)
+	var forward []string
+	for idx := tokens.NextSignificant(-1); idx >= 0; idx = tokens.NextSignificant(idx) {
+		forward = append(forward, tokens[idx].Value)
+	}
+	assert.Equal(t, []string{"a", "=", "1", "b", "(", "2", ")"}, forward)
+
+	var backward []string
+	for idx := tokens.PrevSignificant(len(tokens)); idx >= 0; idx = tokens.PrevSignificant(idx) {
+		backward = append(backward, tokens[idx].Value)
+	}
+	assert.Equal(t, []string{")", "2", "(", "b", "1", "=", "a"}, backward)
+
+	// From the comment, the neighbours are "1" and "b".
+	comment := tokens.Search(7)
+	assert.Equal(t, "1", tokens[tokens.PrevSignificant(comment)].Value)
+	assert.Equal(t, "b", tokens[tokens.NextSignificant(comment)].Value)
+}
+
+func TestEdit(t *testing.T) {
+	edit := Edit{Start: 2, End: 5, Text: []byte("xy")}
+	assert.Equal(t, "abxyf", string(edit.Apply([]byte("abcdef"))))
+	assert.Equal(t, -1, edit.Delta())
+	assert.Equal(t, 2, Edit{Start: 1, End: 1, Text: []byte("ab")}.Delta())
+}
+
+func TestTokenList_Relex(t *testing.T) {
+	const code = "alpha = beta + 12; /* note */ gamma(delta, \"eps\") // end\nzeta"
+	tests := []struct {
+		name       string
+		start, end int
+		text       string
+		stopped    bool // whether lexing stopped before the end of the source
+	}{
+		{"insert in word", 2, 2, "XX", true},
+		{"extend word", 5, 5, "s", true},
+		{"join words", 7, 8, "", true},
+		{"replace number", 15, 17, "3.5", true},
+		{"delete all", 0, len(code), "", false},
+		{"insert at start", 0, 0, "x ", true},
+		{"append", len(code), len(code), " eta", false},
+		{"open comment", 19, 19, "/*", true},
+		{"close comment", 26, 29, "", true},
+		{"open string", 31, 31, "\"", false},
+		{"open line comment", 44, 44, "//", true},
+		{"join lines", 56, 57, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edit := Edit{Start: tt.start, End: tt.end, Text: []byte(tt.text)}
+			edited := edit.Apply([]byte(code))
+			for _, trivia := range []bool{false, true} {
+				original, err := Tokenize(NewLexer("relex.test", []byte(code)), trivia)
+				require.NoError(t, err)
+				want, wantErr := Tokenize(NewLexer("relex.test", edited), trivia)
+
+				l := NewLexer("relex.test", edited)
+				l.SetRecovery(true)
+				got, err := original.Relex(l, edit, trivia)
+				if wantErr != nil {
+					assert.Error(t, err)
+					continue
+				}
+				require.NoError(t, err)
+				assert.Equal(t, want, got, "trivia=%v", trivia)
+				assert.Equal(t, tt.stopped, l.End < len(edited), "trivia=%v", trivia)
+			}
+		})
+	}
+
+	t.Run("modes", func(t *testing.T) {
+		base, _ := interpolationDialects()
+		const code = "a ${b ${c}} d e"
+		original, err := Tokenize(base.NewLexer("relex.test", []byte(code)), false)
+		require.NoError(t, err)
+		edit := Edit{Start: 8, End: 9, Text: []byte("if")}
+		edited := edit.Apply([]byte(code))
+		want, err := Tokenize(base.NewLexer("relex.test", edited), false)
+		require.NoError(t, err)
+		got, err := original.Relex(base.NewLexer("relex.test", edited), edit, false)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("indentation", func(t *testing.T) {
+		const code = "a:\n  b\nc"
+		l := NewLexer("relex.test", []byte(code))
+		l.SetIndentation(true)
+		original, err := Tokenize(l, false)
+		require.NoError(t, err)
+		edit := Edit{Start: 3, End: 3, Text: []byte("  ")}
+		edited := edit.Apply([]byte(code))
+		l = NewLexer("relex.test", edited)
+		l.SetIndentation(true)
+		want, err := Tokenize(l, false)
+		require.NoError(t, err)
+		l = NewLexer("relex.test", edited)
+		l.SetIndentation(true)
+		got, err := original.Relex(l, edit, false)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("line directives", func(t *testing.T) {
+		const code = "a\n#line 100 \"A.tmpl\"\nb\nc"
+		original, err := Tokenize(lineDirectiveDialect().NewLexer("gen.txt", []byte(code)), false)
+		require.NoError(t, err)
+		edit := Edit{Start: len(code), End: len(code), Text: []byte(" d")}
+		edited := edit.Apply([]byte(code))
+		l := lineDirectiveDialect().NewLexer("gen.txt", edited)
+		set := NewFileSet()
+		l.SetFileSet(set)
+		got, err := original.Relex(l, edit, false)
+		require.NoError(t, err)
+		require.Len(t, got, 4)
+		assert.Equal(t, "b", got[1].Value)
+		assert.Equal(t, "A.tmpl:100:1", set.Position(got[1].Pos).String())
+		assert.Equal(t, "A.tmpl:101:3", set.Position(got[3].Pos).String())
+		assert.Equal(t, "A.tmpl:101:3", l.locate(got[3].origin, got[3].StartOffset))
+	})
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkSource)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Tokenize(NewLexer("bench", benchmarkSource), true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package parsing
+
+import "strings"
+
+// Trivia is the whitespace, newlines and comments between significant
+// Symbols. The Parser attaches trivia to the Symbols either side of it, so
+// that concatenating the Text of every Symbol up to and including EOF
+// reproduces the source exactly:
+//
+//   - Trailing trivia is whatever follows a symbol on the same line, up to
+//     and including the end of the line.
+//   - Leading trivia is everything else between a symbol and the one before,
+//     such as blank lines and comments on the lines above it.
+//
+// Trivia at the end of the source is the Leading trivia of the EOF symbol.
+// A symbol's Trailing trivia is complete by the time it becomes Current, but
+// not necessarily while it is only visible through Peek.
+
+// NextSymbol advances to the next significant token and returns it as a
+// Symbol, along with the trivia lexed before it, see SymbolSource.
+func (l *Lexer) NextSymbol() (symbol Symbol, trivia []Symbol) {
+	l.trivia = l.trivia[:0]
+	for {
+		// Intercepts may change mode, so capture the mode the token begins in.
+		mode := l.currentMode()
+		l.Advance()
+		symbol = l.symbol(mode)
+		if IsSignificant(symbol.Token) {
+			break
+		}
+		l.trivia = append(l.trivia, symbol)
+	}
+	if len(l.trivia) > 0 {
+		trivia = l.slab.alloc(len(l.trivia))
+		copy(trivia, l.trivia)
+	}
+	return symbol, trivia
+}
+
+// Text returns the source text of a Symbol including its trivia.
+func (s *Symbol) Text() string {
+	if len(s.Leading) == 0 && len(s.Trailing) == 0 {
+		return s.Value
+	}
+	var text strings.Builder
+	for _, trivia := range s.Leading {
+		text.WriteString(trivia.Value)
+	}
+	text.WriteString(s.Value)
+	for _, trivia := range s.Trailing {
+		text.WriteString(trivia.Value)
+	}
+	return text.String()
+}
+
+// splitTrivia divides the trivia between two symbols into the trailing trivia
+// of the first and the leading trivia of the second. A run of newlines is
+// split after the first line break.
+func splitTrivia(trivia []Symbol) (trailing, leading []Symbol) {
+	for idx, symbol := range trivia {
+		if symbol.Token != NewlineToken {
+			continue
+		}
+		width := 1
+		if strings.HasPrefix(symbol.Value, "\r\n") {
+			width = 2
+		}
+		if width == len(symbol.Value) {
+			return trivia[:idx+1], trivia[idx+1:]
+		}
+		first, rest := symbol, symbol
+		first.Value, first.EndOffset = symbol.Value[:width], symbol.StartOffset+width
+		rest.Value, rest.StartOffset = symbol.Value[width:], symbol.StartOffset+width
+		if rest.Pos.IsValid() {
+			rest.Pos += Pos(width)
+		}
+		trailing = append(trivia[:idx:idx], first)
+		leading = append([]Symbol{rest}, trivia[idx+1:]...)
+		return trailing, leading
+	}
+	// Without a line break, the trivia is all trailing.
+	return trivia, nil
+}
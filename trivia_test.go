@@ -0,0 +1,107 @@
+package parsing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// parserText concatenates the Text of every symbol from the parser's current
+// symbol through EOF.
+func parserText(p *Parser) string {
+	var text strings.Builder
+	for {
+		text.WriteString(p.Current().Text())
+		if p.EOF() {
+			return text.String()
+		}
+		p.Next()
+	}
+}
+
+func TestParser_trivia_roundTrip(t *testing.T) {
+	sources := []string{
+		"",
+		"a",
+		"  \n\n",
+		"// leading\n\na = 1; // trailing\n\n  /* block\n comment */ b(2)\n",
+		"x\r\n\r\n\ty\r\n",
+		"first /* inline */ second\t\n// last",
+		"p - > q",
+	}
+	rules := []Rule{{Sequence: []Token{Minus, SymbolToken}, Applies: readerArrow}}
+	for _, source := range sources {
+		p := NewParser(NewLexer("trivia.test", []byte(source)), rules...)
+		assert.Equal(t, source, parserText(p))
+		withChunkSize(2, func() {
+			p := NewParser(NewReaderLexer("trivia.test", strings.NewReader(source)))
+			assert.Equal(t, source, parserText(p))
+		})
+	}
+}
+
+func TestParser_trivia(t *testing.T) {
+	p := NewParser(NewLexer("trivia.test", []byte("a // c\n\n// d\nb - > c \n")),
+		Rule{Sequence: []Token{Minus, SymbolToken}, Applies: readerArrow})
+	values := func(symbols []Symbol) (result []string) {
+		for _, symbol := range symbols {
+			result = append(result, symbol.Value)
+		}
+		return
+	}
+	a := p.Current()
+	assert.Empty(t, a.Leading)
+	assert.Equal(t, []string{" ", "// c", "\n"}, values(a.Trailing))
+
+	b := p.Peek()
+	assert.Equal(t, []string{"\n", "// d", "\n"}, values(b.Leading))
+	assert.Equal(t, 7, b.Leading[0].StartOffset)
+	// Trailing trivia is only known once the following symbol has been read.
+	assert.Empty(t, b.Trailing)
+
+	p.Next()
+	assert.Equal(t, []string{" "}, values(b.Trailing))
+	p.Next()
+	arrow := p.Current()
+	assert.Equal(t, readerArrow, arrow.Token)
+	assert.Empty(t, arrow.Leading)
+	assert.Equal(t, []string{" "}, values(arrow.Trailing))
+
+	p.Next()
+	assert.Equal(t, []string{" ", "\n"}, values(p.Current().Trailing))
+	p.Next()
+	assert.True(t, p.EOF())
+	assert.Empty(t, p.Current().Leading)
+}
+
+func Test_splitTrivia(t *testing.T) {
+	trivia := []Symbol{
+		{Token: WhitespaceToken, Value: " ", StartOffset: 1, EndOffset: 2},
+		{Token: NewlineToken, Value: "\r\n\r\n", StartOffset: 2, EndOffset: 6},
+		{Token: WhitespaceToken, Value: "\t", StartOffset: 6, EndOffset: 7},
+	}
+	trailing, leading := splitTrivia(trivia)
+	assert.Equal(t, []Symbol{
+		{Token: WhitespaceToken, Value: " ", StartOffset: 1, EndOffset: 2},
+		{Token: NewlineToken, Value: "\r\n", StartOffset: 2, EndOffset: 4},
+	}, trailing)
+	assert.Equal(t, []Symbol{
+		{Token: NewlineToken, Value: "\r\n", StartOffset: 4, EndOffset: 6},
+		{Token: WhitespaceToken, Value: "\t", StartOffset: 6, EndOffset: 7},
+	}, leading)
+	// The input is not modified.
+	assert.Equal(t, "\r\n\r\n", trivia[1].Value)
+
+	trailing, leading = splitTrivia(trivia[:1])
+	assert.Len(t, trailing, 1)
+	assert.Empty(t, leading)
+}
+
+func TestSymbol_Text(t *testing.T) {
+	symbol := &Symbol{Token: IdentifierToken, Value: "x"}
+	assert.Equal(t, "x", symbol.Text())
+	symbol.Leading = []Symbol{{Token: CommentToken, Value: "/**/"}}
+	symbol.Trailing = []Symbol{{Token: WhitespaceToken, Value: " "}, {Token: NewlineToken, Value: "\n"}}
+	assert.Equal(t, "/**/x \n", symbol.Text())
+}